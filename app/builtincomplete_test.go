@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// offered lists the names completion offers at the end of line.
+func offered(line string) []string {
+	runes := []rune(line)
+	var names []string
+	for _, candidate := range (&CustomCompleter{}).complete(wordAtCursor(runes, len(runes)), runes, len(runes)) {
+		names = append(names, candidate.display)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestBuiltinArgumentCompletion(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "projects", "shell"), 0755)
+	os.MkdirAll(filepath.Join(root, "here", "scratch"), 0755)
+	os.WriteFile(filepath.Join(root, "here", "script.sh"), nil, 0644)
+	shell.workingDirectory = filepath.Join(root, "here")
+	t.Setenv("CDPATH", filepath.Join(root, "projects"))
+	t.Setenv("COMPLETION_TEST_VARIABLE", "1")
+
+	exact := map[string][]string{
+		"cd -":                     {"-L", "-P"},
+		"dirs -":                   {"-c", "-l", "-p", "-v"},
+		"history --":               {"--dir", "--failed", "--since"},
+		"set -o vi":                {"vi"},
+		"type ech":                 {"echo"},
+		"echo -":                   {"-E", "-e", "-n"},
+		"exit ":                    nil,
+		"export COMPLETION_TEST_V": {"COMPLETION_TEST_VARIABLE"},
+		"unset COMPLETION_TEST_V":  {"COMPLETION_TEST_VARIABLE"},
+		// cd only offers directories, from here and from CDPATH
+		"cd s":     {"scratch/", "shell/"},
+		"pushd sc": {"scratch/"},
+		// echo falls back to paths
+		"echo scr": {"scratch/", "script.sh"},
+	}
+	for line, want := range exact {
+		if got := offered(line); !slices.Equal(got, want) {
+			t.Errorf("completing %q offered %q, want %q", line, got, want)
+		}
+	}
+
+	// the option lists are long, only some of the names are checked
+	for line, some := range map[string][]string{
+		"shopt -s hist": {"histappend", "histincappend", "histshare"},
+		"shopt -u auto": {"autosuggest"},
+		"set -o ":       {"emacs", "vi", "xtrace"},
+	} {
+		got := offered(line)
+		for _, name := range some {
+			if !slices.Contains(got, name) {
+				t.Errorf("completing %q offered %q, without %q", line, got, name)
+			}
+		}
+	}
+
+	// a spec given with complete takes over from the builtin's own
+	runCommandLine("complete -W 'alpha beta' cd")
+	if got := offered("cd "); !slices.Equal(got, []string{"alpha", "beta"}) {
+		t.Errorf("with a spec for cd, completing offered %q", got)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// completionCandidate is one way to finish the word under the cursor.
+type completionCandidate struct {
+	// suffix is the unquoted text that goes after what was typed
+	suffix string
+	// display is how the candidate is listed after the second Tab
+	display string
+	// terminator follows a unique completion, a space after a finished
+	// word or a slash after a directory
+	terminator string
+	// description is shown next to the candidate in the completion menu
+	description string
+	// typed is the text before the cursor a fuzzy match replaces, suffix
+	// is then the whole name
+	typed string
+	score int
+}
+
+// completionWord is the word the cursor is in, as typed.
+type completionWord struct {
+	raw string
+	// quote is the quote left open at the cursor, if any
+	quote byte
+	// words are the words of the current simple command before raw, the
+	// first of them is the command name
+	words     []string
+	isCommand bool
+}
+
+// wordAtCursor finds the start of the word that ends at pos. Words are
+// split on unquoted blanks and operators, and |, ;, & and ( start a new
+// command, so the word after them is in command position.
+func wordAtCursor(line []rune, pos int) completionWord {
+	text := string(line[:pos])
+	var words []string
+	start := 0
+	var quote byte
+	escaped := false
+
+	endWord := func(end int) {
+		if end > start {
+			words = append(words, text[start:end])
+		}
+		start = end + 1
+	}
+
+	for index := 0; index < len(text); index++ {
+		char := text[index]
+
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else if char == '\\' && quote == '"' {
+				escaped = true
+			}
+		case char == '\\':
+			escaped = true
+		case char == '\'' || char == '"':
+			quote = char
+		case strings.IndexByte(" \t\n<>", char) != -1:
+			endWord(index)
+		case strings.IndexByte("|;&(", char) != -1:
+			endWord(index)
+			// 2>&1 has an & but what follows it is still an argument
+			if char != '&' || index == 0 || (text[index-1] != '>' && text[index-1] != '<') {
+				words = nil
+			}
+		}
+	}
+
+	// a brace group is not a command of its own
+	for len(words) > 0 && words[0] == "{" {
+		words = words[1:]
+	}
+
+	return completionWord{raw: text[start:], quote: quote, words: words, isCommand: len(words) == 0}
+}
+
+// complete lists the candidates for word: command names in command
+// position, the spec registered with complete for the arguments of a
+// command, variable names after $, user names after ~ and file paths
+// everywhere else.
+func (c *CustomCompleter) complete(word completionWord, line []rune, pos int) []completionCandidate {
+	if candidates, ok := variableCandidates(word); ok {
+		return candidates
+	}
+
+	// a command with a spec from complete has its arguments completed by
+	// it, builtins without one complete what they accept
+	if !word.isCommand {
+		command := strings.Join(filterAndJoinArgs(SplitArgs(word.words[0])), "")
+		if spec, ok := specFor(command); ok {
+			return specCandidates(spec, word, line, pos)
+		}
+		if completion, ok := builtinCompletions[command]; ok {
+			return builtinCandidates(completion, word, line, pos)
+		}
+	}
+
+	if word.quote == 0 && strings.HasPrefix(word.raw, "~") && !strings.Contains(word.raw, "/") {
+		return userCandidates(word.raw[1:])
+	}
+
+	if word.isCommand && word.quote == 0 && !strings.Contains(word.raw, "/") {
+		var candidates []completionCandidate
+		seen := map[string]bool{}
+		for _, name := range commandNames() {
+			// a program shadowed by a builtin or an earlier directory is listed once
+			if !strings.HasPrefix(name, word.raw) || seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			description := "shell builtin"
+			if !slices.Contains(builtinTools, name) {
+				description = commandTable.location(name)
+			}
+			candidates = append(candidates, completionCandidate{
+				suffix:      name[len(word.raw):],
+				display:     name,
+				terminator:  " ",
+				description: description,
+			})
+		}
+		return candidates
+	}
+
+	// quotes and escapes are removed and ~ and $VAR expanded the same way
+	// the command line will be
+	expanded := strings.Join(filterAndJoinArgs(SplitArgs(word.raw)), "")
+	return pathCandidates(expanded, word.isCommand)
+}
+
+// pathCandidates completes the last component of path. Hidden files are
+// only offered when the component starts with a dot, and in command
+// position only directories and executables are.
+func pathCandidates(path string, commandsOnly bool) []completionCandidate {
+	directory, base := "", path
+	if slash := strings.LastIndexByte(path, '/'); slash != -1 {
+		directory, base = path[:slash+1], path[slash+1:]
+	}
+
+	searchDirectory := directory
+	if searchDirectory == "" {
+		searchDirectory = "."
+	}
+	searchDirectory, _ = absolutePath(searchDirectory)
+
+	entries, err := os.ReadDir(searchDirectory)
+	if err != nil {
+		return nil
+	}
+
+	var candidates []completionCandidate
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+
+		// symlinks are followed, so a link to a directory gets its slash
+		info, err := os.Stat(filepath.Join(searchDirectory, name))
+		isDir := err == nil && info.IsDir()
+		if commandsOnly && !isDir && (err != nil || info.Mode()&0111 == 0) {
+			continue
+		}
+
+		candidate := completionCandidate{suffix: name[len(base):], display: name, terminator: " "}
+		if isDir {
+			candidate.display += "/"
+			candidate.terminator = "/"
+		}
+		candidate.description = describeFile(filepath.Join(searchDirectory, name), info, err)
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
+
+// variableCandidates completes a $NAME or ${NAME reference at the end of
+// the word. It reports false when the word does not end in one.
+func variableCandidates(word completionWord) ([]completionCandidate, bool) {
+	dollar := strings.LastIndexByte(word.raw, '$')
+	if dollar == -1 || word.quote == '\'' || (dollar > 0 && word.raw[dollar-1] == '\\') {
+		return nil, false
+	}
+
+	prefix, braced := strings.CutPrefix(word.raw[dollar+1:], "{")
+	if prefix != "" && !isValidVariableName(prefix) {
+		return nil, false
+	}
+
+	names := map[string]bool{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		names[name] = true
+	}
+	for name := range shellArrays {
+		names[name] = true
+	}
+
+	var candidates []completionCandidate
+	for name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		candidate := completionCandidate{
+			suffix:      name[len(prefix):],
+			display:     "$" + name,
+			terminator:  " ",
+			description: os.Getenv(name),
+		}
+		switch {
+		case braced:
+			candidate.terminator = "}"
+		case isDirectory(os.Getenv(name)):
+			candidate.terminator = "/"
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, true
+}
+
+// userCandidates completes ~user from the accounts in /etc/passwd.
+func userCandidates(prefix string) []completionCandidate {
+	file, err := os.Open("/etc/passwd")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var candidates []completionCandidate
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		name := fields[0]
+		if name == "" || strings.HasPrefix(name, "#") || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		candidate := completionCandidate{suffix: name[len(prefix):], display: "~" + name, terminator: "/"}
+		if len(fields) > 5 {
+			candidate.description = fields[5]
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
+
+// fuzzyCandidates is what complete falls back to when nothing starts with
+// the word and fuzzy matching is on. The name being typed is dropped from
+// the word, so complete lists every name that could go there, and the ones
+// containing the typed characters in order are kept, best match first.
+func (c *CustomCompleter) fuzzyCandidates(word completionWord, line []rune, pos int) []completionCandidate {
+	// what is typed after the last /, $, { or ~ and after leading dashes
+	// and dots is the name, quoted names are left to prefix matching
+	start := strings.LastIndexAny(word.raw, "/${~") + 1
+	typed := strings.TrimLeft(word.raw[start:], "-.")
+	if typed == "" || word.quote != 0 || strings.ContainsAny(typed, "\\'\"") {
+		return nil
+	}
+
+	widened := word
+	widened.raw = word.raw[:len(word.raw)-len(typed)]
+
+	var candidates []completionCandidate
+	for _, candidate := range c.complete(widened, line, pos) {
+		score, ok := fuzzyScore(candidate.suffix, typed)
+		if !ok {
+			continue
+		}
+		candidate.typed = typed
+		candidate.score = score
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].display < candidates[j].display
+	})
+	return candidates
+}
+
+// fuzzyScore rates how well name matches typed, ignoring case. A name
+// containing typed scores higher the earlier typed starts in it and the
+// shorter it is. Otherwise the characters of typed have to appear in
+// order, and each one right after the previous match or at the start of a
+// word earns points while the characters skipped cost some.
+func fuzzyScore(name, typed string) (int, bool) {
+	lowerName := []rune(strings.ToLower(name))
+	lowerTyped := []rune(strings.ToLower(typed))
+
+	for index := 0; index+len(lowerTyped) <= len(lowerName); index++ {
+		if slices.Equal(lowerName[index:index+len(lowerTyped)], lowerTyped) {
+			return 1000 - 10*index - (len(lowerName) - len(lowerTyped)), true
+		}
+	}
+
+	score := 0
+	next, previous := 0, -2
+	for _, char := range lowerTyped {
+		found := slices.Index(lowerName[next:], char)
+		if found == -1 {
+			return 0, false
+		}
+		index := next + found
+
+		switch {
+		case index == previous+1:
+			score += 15
+		case index == 0 || strings.ContainsRune("-_. /", lowerName[index-1]):
+			score += 10
+		}
+		score -= found
+
+		previous, next = index, index+1
+	}
+
+	return score, true
+}
+
+// describeFile tells what kind of file a completed path names for the
+// completion menu, or its size for a regular file.
+func describeFile(path string, info os.FileInfo, err error) string {
+	if link, linkErr := os.Readlink(path); linkErr == nil {
+		return "-> " + link
+	}
+
+	switch {
+	case err != nil:
+		return ""
+	case info.IsDir():
+		return "directory"
+	case info.Mode()&0111 != 0:
+		return "executable"
+	}
+
+	size := float64(info.Size())
+	for _, unit := range []string{"B", "K", "M", "G"} {
+		if size < 1024 || unit == "G" {
+			if unit == "B" {
+				return fmt.Sprintf("%.0f%s", size, unit)
+			}
+			return fmt.Sprintf("%.1f%s", size, unit)
+		}
+		size /= 1024
+	}
+	return ""
+}
+
+func isDirectory(path string) bool {
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// escapeCompletion quotes completed text so it reads back as typed: inside
+// double quotes only ", \, $ and ` need a backslash, inside single quotes
+// a quote has to be closed and reopened.
+func escapeCompletion(text string, quote byte) string {
+	var builder strings.Builder
+
+	for _, char := range text {
+		switch quote {
+		case '\'':
+			if char == '\'' {
+				builder.WriteString(`'\''`)
+				continue
+			}
+		case '"':
+			if strings.ContainsRune("\"\\$`", char) {
+				builder.WriteByte('\\')
+			}
+		default:
+			if strings.ContainsRune(" \t\n\"'\\$&;|<>()*?[]{}!#`", char) {
+				builder.WriteByte('\\')
+			}
+		}
+		builder.WriteRune(char)
+	}
+
+	return builder.String()
+}
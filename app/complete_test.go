@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestFuzzyScoreNoMatch(t *testing.T) {
+	tests := []struct{ name, typed string }{
+		{name: "xyz", typed: "abc"},
+		{name: "ab", typed: "abc"},
+		{name: "cba", typed: "abc"},
+	}
+
+	for _, test := range tests {
+		if score, ok := fuzzyScore(test.name, test.typed); ok {
+			t.Errorf("fuzzyScore(%q, %q) = %d, want no match", test.name, test.typed, score)
+		}
+	}
+}
+
+func TestFuzzyScoreOrder(t *testing.T) {
+	// each name is a better match for typed than the one after it
+	tests := []struct {
+		typed string
+		names []string
+	}{
+		{typed: "conf", names: []string{"config", "configure", "myconf", "c-o-n-f"}},
+		{typed: "dl", names: []string{"dl", "dir-list", "Downloads", "modules"}},
+		{typed: "check", names: []string{"checkout", "recheck"}},
+		{typed: "make", names: []string{"Makefile", "remake"}},
+		{typed: "abc", names: []string{"abc", "axbc"}},
+	}
+
+	for _, test := range tests {
+		previous := 0
+		for index, name := range test.names {
+			score, ok := fuzzyScore(name, test.typed)
+			if !ok {
+				t.Errorf("fuzzyScore(%q, %q) did not match", name, test.typed)
+				continue
+			}
+			if index > 0 && score >= previous {
+				t.Errorf("fuzzyScore(%q, %q) = %d, not below %q with %d", name, test.typed, score, test.names[index-1], previous)
+			}
+			previous = score
+		}
+	}
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// completionSpec is how complete says the arguments of a command are
+// completed.
+type completionSpec struct {
+	// actions are built in generators such as file or command
+	actions  []string
+	wordList string
+	// function is the command line of -F, which leaves its candidates in
+	// COMPREPLY
+	function string
+	command  string
+	// options holds the -o names: filenames, nospace and default
+	options []string
+}
+
+// completionSpecs maps command names to their spec.
+var completionSpecs = map[string]completionSpec{}
+
+var completionOptionNames = []string{"default", "filenames", "nospace"}
+
+// completionActions maps the -A names to the single letter options.
+var completionActions = map[string]byte{
+	"builtin":   'b',
+	"command":   'c',
+	"directory": 'd',
+	"file":      'f',
+	"user":      'u',
+	"variable":  'v',
+}
+
+// builtinCompletion is how a builtin completes its own arguments when
+// complete was not given a spec for it.
+type builtinCompletion struct {
+	// flags are offered for words that start with a dash
+	flags string
+	spec  completionSpec
+}
+
+// builtinCompletions also uses actions complete -A does not offer: cdpath
+// completes directories here and in CDPATH, shopt and setoption the option
+// names, binding the functions keys are bound to.
+var builtinCompletions = map[string]builtinCompletion{
+	"cd":       {flags: "-L -P", spec: completionSpec{actions: []string{"cdpath"}}},
+	"pushd":    {flags: "-n", spec: completionSpec{actions: []string{"cdpath"}}},
+	"popd":     {flags: "-n"},
+	"dirs":     {flags: "-c -l -p -v"},
+	"pwd":      {flags: "-L -P"},
+	"type":     {spec: completionSpec{actions: []string{"command"}}},
+	"hash":     {flags: "-d -l -p -r -t", spec: completionSpec{actions: []string{"command"}}},
+	"history":  {flags: "-a -c -d -n -p -r -s -w --dir --failed --since", spec: completionSpec{options: []string{"default"}}},
+	"fc":       {flags: "-e -l -n -r -s"},
+	"export":   {flags: "-p", spec: completionSpec{actions: []string{"variable"}}},
+	"unset":    {flags: "-f -v", spec: completionSpec{actions: []string{"variable"}}},
+	"read":     {flags: "-a -d -n -p -r -s -t", spec: completionSpec{actions: []string{"variable"}}},
+	"shopt":    {flags: "-p -q -s -u", spec: completionSpec{actions: []string{"shopt"}}},
+	"set":      {flags: "-o -x", spec: completionSpec{actions: []string{"setoption"}}},
+	"bind":     {flags: "-P -V -X -l -m -p -r -v -x"},
+	"bindkey":  {flags: "-L -M -e -l -r -v", spec: completionSpec{actions: []string{"binding"}}},
+	"complete": {flags: "-A -C -F -W -o -p -r -b -c -d -f -u -v", spec: completionSpec{actions: []string{"command"}}},
+	"compgen":  {flags: "-A -C -F -V -W -o -b -c -d -f -u -v"},
+	"echo":     {flags: "-e -E -n", spec: completionSpec{options: []string{"default"}}},
+	"printf":   {flags: "-v", spec: completionSpec{options: []string{"default"}}},
+	"exit":     {},
+}
+
+// builtinCandidates completes the arguments of a builtin that declared how.
+func builtinCandidates(completion builtinCompletion, word completionWord, line []rune, pos int) []completionCandidate {
+	if strings.HasPrefix(word.raw, "-") && completion.flags != "" {
+		var candidates []completionCandidate
+		for _, flag := range strings.Fields(completion.flags) {
+			if strings.HasPrefix(flag, word.raw) {
+				candidates = append(candidates, completionCandidate{
+					suffix:     flag[len(word.raw):],
+					display:    flag,
+					terminator: " ",
+				})
+			}
+		}
+		return candidates
+	}
+
+	return specCandidates(completion.spec, word, line, pos)
+}
+
+// completionContext is what a spec is asked to complete.
+type completionContext struct {
+	line  string
+	point int
+	// words are the words of the command up to and including the current one
+	words []string
+}
+
+// specFor returns the spec of a command, looked up by its name as typed
+// and then by the name without its directory.
+func specFor(command string) (completionSpec, bool) {
+	if spec, ok := completionSpecs[command]; ok {
+		return spec, true
+	}
+	spec, ok := completionSpecs[filepath.Base(command)]
+	return spec, ok
+}
+
+// parseCompletionSpec reads the options shared by complete and compgen and
+// returns the words left after them.
+func parseCompletionSpec(words []string, flags *string) (spec completionSpec, rest []string, err error) {
+	for len(words) > 0 {
+		word := words[0]
+		if word == "--" {
+			words = words[1:]
+			break
+		}
+		if len(word) < 2 || word[0] != '-' {
+			break
+		}
+		words = words[1:]
+
+		for i := 1; i < len(word); i++ {
+			flag := word[i]
+
+			if strings.IndexByte("WFCoA", flag) == -1 {
+				if action, ok := actionName(flag); ok {
+					spec.actions = appendAction(spec.actions, action)
+					continue
+				}
+				// -p and -r only exist for complete itself
+				if flags == nil || strings.IndexByte("pr", flag) == -1 {
+					return spec, nil, fmt.Errorf("-%c: invalid option", flag)
+				}
+				*flags += string(flag)
+				continue
+			}
+
+			// the argument is the rest of the word or the next word
+			value := word[i+1:]
+			if value == "" {
+				if len(words) == 0 {
+					return spec, nil, fmt.Errorf("-%c: option requires an argument", flag)
+				}
+				value, words = words[0], words[1:]
+			}
+			i = len(word)
+
+			switch flag {
+			case 'W':
+				spec.wordList = value
+			case 'F':
+				spec.function = value
+			case 'C':
+				spec.command = value
+			case 'o':
+				if !slices.Contains(completionOptionNames, value) {
+					return spec, nil, fmt.Errorf("%s: invalid option name", value)
+				}
+				if !slices.Contains(spec.options, value) {
+					spec.options = append(spec.options, value)
+				}
+			case 'A':
+				if _, ok := completionActions[value]; !ok {
+					return spec, nil, fmt.Errorf("%s: invalid action name", value)
+				}
+				spec.actions = appendAction(spec.actions, value)
+			}
+		}
+	}
+
+	return spec, words, nil
+}
+
+// actionName finds the action of a single letter option such as -f.
+func actionName(letter byte) (string, bool) {
+	for name, actionLetter := range completionActions {
+		if actionLetter == letter {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func appendAction(actions []string, action string) []string {
+	if slices.Contains(actions, action) {
+		return actions
+	}
+	return append(actions, action)
+}
+
+// matches generates the words the spec offers for word, in the order the
+// actions, the word list, the function and the command produce them.
+func (spec completionSpec) matches(word string, context completionContext) []string {
+	var matches []string
+	add := func(candidate string) {
+		if strings.HasPrefix(candidate, word) && !slices.Contains(matches, candidate) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	for _, action := range spec.actions {
+		for _, candidate := range actionMatches(action, word) {
+			add(candidate)
+		}
+	}
+
+	if spec.wordList != "" {
+		for _, candidate := range filterAndJoinArgs(SplitArgs(spec.wordList)) {
+			add(candidate)
+		}
+	}
+
+	// like bash, what the function leaves in COMPREPLY is used as it is
+	if spec.function != "" {
+		for _, candidate := range runCompletionFunction(spec.function, context) {
+			if !slices.Contains(matches, candidate) {
+				matches = append(matches, candidate)
+			}
+		}
+	}
+
+	if spec.command != "" {
+		for _, candidate := range runCompletionCommand(spec.command, word, context) {
+			add(candidate)
+		}
+	}
+
+	return matches
+}
+
+// actionMatches lists the candidates of a built in action for word.
+func actionMatches(action string, word string) []string {
+	var candidates []string
+
+	switch action {
+	case "builtin":
+		candidates = slices.Clone(builtinTools)
+
+	case "command":
+		candidates = commandNames()
+
+	case "file", "directory":
+		directory := ""
+		if slash := strings.LastIndexByte(word, '/'); slash != -1 {
+			directory = word[:slash+1]
+		}
+		for _, candidate := range pathCandidates(word, false) {
+			if action == "directory" && candidate.terminator != "/" {
+				continue
+			}
+			candidates = append(candidates, directory+strings.TrimSuffix(candidate.display, "/"))
+		}
+
+	case "cdpath":
+		directory := ""
+		if slash := strings.LastIndexByte(word, '/'); slash != -1 {
+			directory = word[:slash+1]
+		}
+
+		// like cd itself, names starting with / ./ or ../ skip CDPATH
+		bases := []string{""}
+		if !filepath.IsAbs(word) && !strings.HasPrefix(word, "./") && !strings.HasPrefix(word, "../") {
+			for _, base := range filepath.SplitList(os.Getenv("CDPATH")) {
+				if base != "" {
+					bases = append(bases, strings.TrimSuffix(base, "/")+"/")
+				}
+			}
+		}
+
+		for _, base := range bases {
+			for _, candidate := range pathCandidates(base+word, false) {
+				if candidate.terminator == "/" {
+					candidates = append(candidates, directory+strings.TrimSuffix(candidate.display, "/"))
+				}
+			}
+		}
+
+	case "shopt":
+		for name := range shellOptions {
+			candidates = append(candidates, name)
+		}
+
+	case "setoption":
+		for name := range setOptions {
+			candidates = append(candidates, name)
+		}
+
+	case "binding":
+		for name := range editingActions {
+			candidates = append(candidates, name)
+		}
+
+	case "user":
+		for _, candidate := range userCandidates(word) {
+			candidates = append(candidates, strings.TrimPrefix(candidate.display, "~"))
+		}
+
+	case "variable":
+		for _, variable := range os.Environ() {
+			name, _, _ := strings.Cut(variable, "=")
+			candidates = append(candidates, name)
+		}
+		for name := range shellArrays {
+			candidates = append(candidates, name)
+		}
+	}
+
+	sort.Strings(candidates)
+	return candidates
+}
+
+// runCompletionCommand runs the command of complete -C in a subshell. Like
+// in bash it gets the command name, the word and the previous word as
+// arguments and COMP_LINE and COMP_POINT in its environment, and prints one
+// candidate per line.
+func runCompletionCommand(command string, word string, context completionContext) []string {
+	name, previous := "", ""
+	if len(context.words) > 0 {
+		name = context.words[0]
+	}
+	if len(context.words) > 1 {
+		previous = context.words[len(context.words)-2]
+	}
+
+	line := command
+	for _, argument := range []string{name, word, previous} {
+		line += " " + quoteWord(argument)
+	}
+
+	cmd := subshellCommand(line)
+	cmd.Env = append(os.Environ(),
+		"COMP_LINE="+context.line,
+		"COMP_POINT="+strconv.Itoa(context.point),
+	)
+
+	// whatever a failing command printed is still used
+	output, _ := cmd.Output()
+
+	var candidates []string
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		if candidate := scanner.Text(); candidate != "" {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// runCompletionFunction runs the command line given to -F in the shell
+// itself, on a copy of its state like a builtin in a pipeline. The shell has
+// no functions or positional parameters, so unlike in bash the command is
+// not given the command name and the words around the cursor: it finds
+// them in COMP_WORDS and COMP_CWORD, and leaves its candidates in the
+// COMPREPLY array, for instance with compgen -V COMPREPLY.
+func runCompletionFunction(function string, context completionContext) []string {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.pipelineStage = true
+
+	setArray("COMP_WORDS", context.words)
+	setVariable("COMP_CWORD", strconv.Itoa(max(len(context.words)-1, 0)))
+	setVariable("COMP_LINE", context.line)
+	setVariable("COMP_POINT", strconv.Itoa(context.point))
+	delete(shellArrays, "COMPREPLY")
+	os.Unsetenv("COMPREPLY")
+
+	// what it prints would end up in the middle of the line being edited
+	captureOutput(func() { runCommandLine(function) })
+
+	if values, ok := shellArrays["COMPREPLY"]; ok {
+		return slices.Clone(values)
+	}
+	if value := os.Getenv("COMPREPLY"); value != "" {
+		return []string{value}
+	}
+	return nil
+}
+
+// quoteWord single quotes text so the parser reads it back unchanged.
+func quoteWord(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
+
+// specCandidates turns the matches of spec into completion candidates.
+// With -o default an empty result falls back to file completion.
+func specCandidates(spec completionSpec, word completionWord, line []rune, pos int) []completionCandidate {
+	expanded := strings.Join(filterAndJoinArgs(SplitArgs(word.raw)), "")
+
+	context := completionContext{
+		line:  string(line),
+		point: len(string(line[:pos])),
+		words: append(slices.Clone(word.words), word.raw),
+	}
+
+	matches := spec.matches(expanded, context)
+
+	if len(matches) == 0 && slices.Contains(spec.options, "default") {
+		return pathCandidates(expanded, false)
+	}
+
+	cdPath := slices.Contains(spec.actions, "cdpath")
+	filenames := slices.Contains(spec.options, "filenames") || cdPath ||
+		slices.Contains(spec.actions, "file") || slices.Contains(spec.actions, "directory")
+
+	var candidates []completionCandidate
+	for _, match := range matches {
+		candidate := completionCandidate{suffix: match[len(expanded):], display: match, terminator: " "}
+
+		if filenames {
+			// like file completion, only the last component is listed and
+			// directories get a slash instead of a space
+			candidate.display = filepath.Base(match)
+			path, _ := absolutePath(match)
+			if _, inCDPath := searchCDPath(match); isDirectory(path) || (cdPath && inCDPath) {
+				candidate.display += "/"
+				candidate.terminator = "/"
+			}
+		}
+		if slices.Contains(spec.options, "nospace") && candidate.terminator == " " {
+			candidate.terminator = ""
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
+
+// formatSpec prints a spec the way complete -p does, so the output can be
+// run again.
+func formatSpec(name string, spec completionSpec) string {
+	var builder strings.Builder
+	builder.WriteString("complete")
+
+	for _, option := range spec.options {
+		builder.WriteString(" -o " + option)
+	}
+	for _, action := range spec.actions {
+		builder.WriteString(fmt.Sprintf(" -%c", completionActions[action]))
+	}
+	if spec.wordList != "" {
+		builder.WriteString(" -W " + quoteWord(spec.wordList))
+	}
+	if spec.function != "" {
+		builder.WriteString(" -F " + quoteWord(spec.function))
+	}
+	if spec.command != "" {
+		builder.WriteString(" -C " + quoteWord(spec.command))
+	}
+
+	builder.WriteString(" " + name + "\n")
+	return builder.String()
+}
+
+func handleComplete(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	var flags string
+	spec, names, err := parseCompletionSpec(filterAndJoinArgs(args[1:]), &flags)
+	if err != nil {
+		outputStream(strings.NewReader(fmt.Sprintf("complete: %v\n", err)), redirectionTargets, true)
+		shell.exitStatus = 2
+		return
+	}
+
+	if strings.Contains(flags, "r") {
+		if len(names) == 0 {
+			clear(completionSpecs)
+		}
+		for _, name := range names {
+			delete(completionSpecs, name)
+		}
+		return
+	}
+
+	isEmpty := len(spec.actions) == 0 && len(spec.options) == 0 &&
+		spec.wordList == "" && spec.function == "" && spec.command == ""
+
+	if strings.Contains(flags, "p") || len(names) == 0 || isEmpty {
+		if len(names) == 0 {
+			for name := range completionSpecs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+
+		var result strings.Builder
+		for _, name := range names {
+			spec, ok := completionSpecs[name]
+			if !ok {
+				outputStream(strings.NewReader(fmt.Sprintf("complete: %s: no completion specification\n", name)), redirectionTargets, true)
+				shell.exitStatus = 1
+				continue
+			}
+			result.WriteString(formatSpec(name, spec))
+		}
+		outputStream(strings.NewReader(result.String()), redirectionTargets, false)
+		return
+	}
+
+	for _, name := range names {
+		completionSpecs[name] = spec
+	}
+}
+
+func handleCompgen(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	// -V name stores the matches in the array name instead of printing them
+	arrayName := ""
+	if index := slices.Index(words, "-V"); index != -1 && index+1 < len(words) {
+		arrayName = words[index+1]
+		words = slices.Delete(words, index, index+2)
+		if !isValidVariableName(arrayName) {
+			outputStream(strings.NewReader(fmt.Sprintf("compgen: `%s': not a valid identifier\n", arrayName)), redirectionTargets, true)
+			shell.exitStatus = 2
+			return
+		}
+	}
+
+	spec, rest, err := parseCompletionSpec(words, nil)
+	if err != nil {
+		outputStream(strings.NewReader(fmt.Sprintf("compgen: %v\n", err)), redirectionTargets, true)
+		shell.exitStatus = 2
+		return
+	}
+
+	word := ""
+	if len(rest) > 0 {
+		word = rest[0]
+	}
+
+	matches := spec.matches(word, completionContext{words: []string{word}})
+
+	if arrayName != "" {
+		setArray(arrayName, matches)
+	}
+	if len(matches) == 0 {
+		shell.exitStatus = 1
+		return
+	}
+	if arrayName != "" {
+		return
+	}
+
+	outputStream(strings.NewReader(strings.Join(matches, "\n")+"\n"), redirectionTargets, false)
+}
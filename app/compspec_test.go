@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompletionFunction(t *testing.T) {
+	tests := []struct {
+		name     string
+		function string
+		words    []string
+		want     []string
+	}{
+		{
+			name:     "compgen fills COMPREPLY",
+			function: `compgen -V COMPREPLY -W "alpha beta above" -- ${COMP_WORDS[COMP_CWORD]}`,
+			words:    []string{"tool", "a"},
+			want:     []string{"alpha", "above"},
+		},
+		{
+			name:     "previous word",
+			function: `compgen -V COMPREPLY -W "${COMP_WORDS[1]}-x"`,
+			words:    []string{"tool", "build", ""},
+			want:     []string{"build-x"},
+		},
+		{
+			name:     "printed candidates are ignored",
+			function: "echo alpha",
+			words:    []string{"tool", "a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := completionSpec{function: test.function}
+			word := test.words[len(test.words)-1]
+			got := spec.matches(word, completionContext{words: test.words})
+			if !slices.Equal(got, test.want) {
+				t.Errorf("-F %q completing %q = %q, want %q", test.function, test.words, got, test.want)
+			}
+			if _, ok := shellArrays["COMPREPLY"]; ok {
+				t.Errorf("-F %q left COMPREPLY set in the shell", test.function)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// inputState is what is left open at the end of the lines read so far.
+type inputState struct {
+	// hereDocument is set while the body of a here-document has not ended
+	hereDocument bool
+	quote        bool
+	// escaped is set when the input ends with a backslash
+	escaped bool
+	// open is set while a group or an if, for, while or until is open
+	open bool
+	// lastToken is the last unquoted word or operator
+	lastToken string
+}
+
+// compoundOpeners and compoundClosers are the reserved words that open
+// and close a compound command.
+var (
+	compoundOpeners = []string{"if", "for", "while", "until"}
+	compoundClosers = []string{"fi", "done"}
+	// commandPrefixes leave the next word in command position
+	commandPrefixes = []string{"if", "then", "else", "elif", "while", "until", "do", "!", "{"}
+)
+
+// incomplete reports whether the command needs more lines before it can
+// run: a quote, a here-document or a group is open, or the input ends with
+// a backslash or with |, && or ||.
+func (state inputState) incomplete() bool {
+	return state.hereDocument || state.quote || state.escaped || state.open ||
+		slices.Contains([]string{"|", "&&", "||"}, state.lastToken)
+}
+
+// scanInput reads text the way the parser will, to tell whether it is a
+// complete command.
+func scanInput(text string) inputState {
+	var state inputState
+
+	command, _, complete := parseHereDocuments(text)
+	state.hereDocument = !complete
+
+	scanner := rawScanner{input: command}
+	var word strings.Builder
+	wordQuoted := false
+	commandPosition := true
+	depth := 0
+
+	endWord := func() {
+		if word.Len() == 0 {
+			return
+		}
+		text := word.String()
+		word.Reset()
+
+		// like the parser, } closes a group wherever it stands
+		switch {
+		case wordQuoted:
+		case commandPosition && (text == "{" || slices.Contains(compoundOpeners, text)):
+			depth++
+		case text == "}" || (commandPosition && slices.Contains(compoundClosers, text)):
+			depth--
+		}
+		commandPosition = commandPosition && !wordQuoted && slices.Contains(commandPrefixes, text)
+		wordQuoted = false
+		state.lastToken = text
+	}
+
+	for index := 0; index < len(command); index++ {
+		char := command[index]
+		quoted := scanner.activeQuote != 0 || scanner.escaped
+		scanner.step(index)
+
+		switch {
+		case quoted || char == '\\' || char == '\'' || char == '"':
+			word.WriteByte(char)
+			wordQuoted = true
+		case char == ' ' || char == '\t':
+			endWord()
+		case strings.ContainsRune("\n;|&()", rune(char)):
+			endWord()
+			state.lastToken = string(char)
+			if index+1 < len(command) && (char == '|' || char == '&') && command[index+1] == char {
+				state.lastToken += string(char)
+				index++
+				scanner.step(index)
+			}
+			commandPosition = true
+		default:
+			word.WriteByte(char)
+		}
+	}
+	endWord()
+
+	state.quote = scanner.activeQuote != 0
+	state.escaped = scanner.escaped
+	state.open = depth > 0 || scanner.parenDepth > 0
+	return state
+}
+
+// joinInput adds the line next to the incomplete command text. A
+// backslash and the newline after it are removed, inside quotes and
+// around here-documents the newline is kept, and elsewhere the line goes
+// on the same line as the command so the whole command is a single
+// history entry.
+func joinInput(text, next string) string {
+	state := scanInput(text)
+	_, documents, _ := parseHereDocuments(text)
+
+	switch {
+	case state.escaped:
+		return text[:len(text)-1] + next
+	case state.quote || len(documents) > 0:
+		// the bodies of here-documents are lines of their own
+		return text + "\n" + next
+	case strings.TrimSpace(next) == "":
+		return text
+	case slices.Contains([]string{"|", "&&", "||", ";", "&", "(", "\n"}, state.lastToken) || slices.Contains(commandPrefixes, state.lastToken):
+		return text + " " + strings.TrimLeft(next, " \t")
+	}
+	return text + "; " + strings.TrimLeft(next, " \t")
+}
+
+// readContinuation keeps reading lines after PS2 while line is not a
+// complete command, and returns the whole command.
+func readContinuation(line string, interactive bool) (string, error) {
+	for scanInput(line).incomplete() {
+		var next string
+		var err error
+
+		if interactive {
+			prompt := continuationPrompt()
+			fmt.Print(prompt.header)
+			shell.keys.setPrompt(prompt.prompt)
+
+			shell.editingCommand = true
+			next, err = shell.rl.Readline()
+			shell.editingCommand = false
+			// a bind -x key only runs commands at the primary prompt
+			shell.keys.command = ""
+		} else {
+			next, err = stdinReader.ReadString('\n')
+			next = strings.TrimSuffix(next, "\n")
+			if err == io.EOF && next != "" {
+				err = nil
+			}
+		}
+		if err != nil {
+			return line, err
+		}
+
+		line = joinInput(line, next)
+	}
+
+	return line, nil
+}
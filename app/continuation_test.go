@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestScanInput(t *testing.T) {
+	tests := []struct {
+		text       string
+		incomplete bool
+	}{
+		{text: "echo hi", incomplete: false},
+		{text: "echo hi |", incomplete: true},
+		{text: "true &&", incomplete: true},
+		{text: "false ||", incomplete: true},
+		{text: "echo a;", incomplete: false},
+		{text: "echo 'open", incomplete: true},
+		{text: `echo "open`, incomplete: true},
+		{text: `echo "a|"`, incomplete: false},
+		{text: `echo \`, incomplete: true},
+		{text: `echo \\`, incomplete: false},
+		{text: "{ echo a", incomplete: true},
+		{text: "{ echo a; }", incomplete: false},
+		{text: "echo {", incomplete: false},
+		{text: "echo '{'; {", incomplete: true},
+		{text: "(echo a", incomplete: true},
+		{text: "(echo a)", incomplete: false},
+		{text: "if true; then echo a", incomplete: true},
+		{text: "if true; then echo a; fi", incomplete: false},
+		{text: "echo if", incomplete: false},
+		{text: "for x in a b; do echo $x", incomplete: true},
+		{text: "for x in a b; do echo $x; done", incomplete: false},
+		{text: "cat <<EOF", incomplete: true},
+		{text: "cat <<EOF\nbody", incomplete: true},
+		{text: "cat <<EOF\nbody\nEOF", incomplete: false},
+		{text: "echo '<<EOF'", incomplete: false},
+	}
+
+	for _, test := range tests {
+		if got := scanInput(test.text).incomplete(); got != test.incomplete {
+			t.Errorf("scanInput(%q).incomplete() = %v, want %v", test.text, got, test.incomplete)
+		}
+	}
+}
+
+func TestJoinInput(t *testing.T) {
+	tests := []struct {
+		text, next string
+		want       string
+	}{
+		{text: `echo a\`, next: "b", want: "echo ab"},
+		{text: "echo 'a", next: "b'", want: "echo 'a\nb'"},
+		{text: "echo a |", next: "  wc -l", want: "echo a | wc -l"},
+		{text: "true &&", next: "echo ok", want: "true && echo ok"},
+		{text: "{ echo a", next: "echo b; }", want: "{ echo a; echo b; }"},
+		{text: "{", next: "echo a", want: "{ echo a"},
+		{text: "if true; then", next: "echo a", want: "if true; then echo a"},
+		{text: "{ echo a", next: "   ", want: "{ echo a"},
+		{text: "cat <<EOF", next: "body", want: "cat <<EOF\nbody"},
+	}
+
+	for _, test := range tests {
+		if got := joinInput(test.text, test.next); got != test.want {
+			t.Errorf("joinInput(%q, %q) = %q, want %q", test.text, test.next, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var errNotADirectory = errors.New("Not a directory")
+
+// initWorkingDirectory sets the logical directory to the one we started in.
+// An inherited PWD is kept when it points to the same place, so a shell
+// started inside a symlinked directory keeps showing the symlink.
+func initWorkingDirectory() {
+	physical, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	inherited := os.Getenv("PWD")
+	if filepath.IsAbs(inherited) && isSameFile(inherited, physical) {
+		shell.workingDirectory = filepath.Clean(inherited)
+	} else {
+		shell.workingDirectory = physical
+	}
+
+	setVariable("PWD", shell.workingDirectory)
+}
+
+func currentDirectory() string {
+	if shell.workingDirectory != "" {
+		return shell.workingDirectory
+	}
+
+	physical, _ := os.Getwd()
+	return physical
+}
+
+// changeDirectory moves the shell to path and keeps PWD and OLDPWD up to
+// date. Only the shell state changes, the process never calls os.Chdir.
+// Relative paths are resolved against the logical PWD, so ".." leaves a
+// symlinked directory the way it was entered. With physical set, symlinks
+// are resolved and PWD holds the real path.
+func changeDirectory(path string, physical bool) error {
+	previous := currentDirectory()
+
+	destination := path
+	if !filepath.IsAbs(destination) {
+		destination = filepath.Join(previous, destination)
+	}
+	destination = filepath.Clean(destination)
+
+	if physical {
+		resolved, err := filepath.EvalSymlinks(destination)
+		if err != nil {
+			return err
+		}
+		destination = resolved
+	}
+
+	info, err := os.Stat(destination)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errNotADirectory
+	}
+
+	shell.workingDirectory = destination
+	setVariable("OLDPWD", previous)
+	setVariable("PWD", destination)
+	return nil
+}
+
+// searchCDPath returns the first CDPATH entry containing path. Paths that
+// are absolute or start with . or .. are never looked up in CDPATH.
+func searchCDPath(path string) (string, bool) {
+	cdPath := os.Getenv("CDPATH")
+	if cdPath == "" || filepath.IsAbs(path) || path == "." || path == ".." ||
+		strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		return "", false
+	}
+
+	for _, base := range filepath.SplitList(cdPath) {
+		// an empty entry stands for the current directory
+		if base == "" {
+			base = currentDirectory()
+		} else if !filepath.IsAbs(base) {
+			base = filepath.Join(currentDirectory(), base)
+		}
+
+		candidate := filepath.Join(base, path)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+func dirsEntries() []string {
+	return append([]string{currentDirectory()}, shell.directoryStack...)
+}
+
+// stackIndex converts a +N or -N argument into an index of dirsEntries.
+func stackIndex(argument string, size int) (int, bool) {
+	if len(argument) < 2 || (argument[0] != '+' && argument[0] != '-') {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(argument[1:])
+	if err != nil || n < 0 || n >= size {
+		return 0, false
+	}
+
+	if argument[0] == '-' {
+		return size - 1 - n, true
+	}
+	return n, true
+}
+
+// abbreviateHome replaces the HOME prefix of path with a tilde.
+func abbreviateHome(path string) string {
+	home := os.Getenv("HOME")
+	if home == "" || home == "/" {
+		return path
+	}
+
+	if path == home {
+		return "~"
+	}
+	if strings.HasPrefix(path, home+"/") {
+		return "~" + path[len(home):]
+	}
+	return path
+}
+
+func formatDirs(long bool, perLine bool, numbered bool) string {
+	var builder strings.Builder
+
+	for index, entry := range dirsEntries() {
+		if !long {
+			entry = abbreviateHome(entry)
+		}
+
+		switch {
+		case numbered:
+			builder.WriteString(fmt.Sprintf("%2d  %s\n", index, entry))
+		case perLine:
+			builder.WriteString(entry + "\n")
+		default:
+			if index > 0 {
+				builder.WriteString(" ")
+			}
+			builder.WriteString(entry)
+		}
+	}
+
+	if !numbered && !perLine {
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
+
+func handleDirs(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	var long, perLine, numbered bool
+
+	for _, word := range filterAndJoinArgs(args[1:]) {
+		if index, ok := stackIndex(word, len(dirsEntries())); ok {
+			entry := dirsEntries()[index]
+			if !long {
+				entry = abbreviateHome(entry)
+			}
+			outputStream(strings.NewReader(entry+"\n"), redirectionTargets, false)
+			return
+		}
+
+		switch word {
+		case "-c":
+			shell.directoryStack = nil
+			return
+		case "-l":
+			long = true
+		case "-p":
+			perLine = true
+		case "-v":
+			numbered = true
+		default:
+			outputStream(
+				strings.NewReader(fmt.Sprintf("dirs: %s: invalid option\n", word)),
+				redirectionTargets,
+				true,
+			)
+			shell.exitStatus = 1
+			return
+		}
+	}
+
+	outputStream(
+		strings.NewReader(formatDirs(long, perLine, numbered)),
+		redirectionTargets,
+		false,
+	)
+}
+
+func handlePushd(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	noChange := false
+	if len(words) > 0 && words[0] == "-n" {
+		noChange = true
+		words = words[1:]
+	}
+
+	fail := func(message string) {
+		outputStream(strings.NewReader("pushd: "+message+"\n"), redirectionTargets, true)
+		shell.exitStatus = 1
+	}
+
+	entries := dirsEntries()
+
+	switch {
+	case len(words) == 0:
+		// swap the two top directories
+		if len(shell.directoryStack) == 0 {
+			fail("no other directory")
+			return
+		}
+		if !noChange {
+			if err := changeDirectory(shell.directoryStack[0], false); err != nil {
+				fail(fmt.Sprintf("%s: %v", shell.directoryStack[0], describePathError(err)))
+				return
+			}
+		}
+		shell.directoryStack[0] = entries[0]
+
+	case len(words[0]) > 1 && (words[0][0] == '+' || words[0][0] == '-'):
+		// bring the Nth entry to the top by rotating the stack
+		index, ok := stackIndex(words[0], len(entries))
+		if !ok {
+			fail(fmt.Sprintf("%s: directory stack index out of range", words[0]))
+			return
+		}
+		rotated := slices.Concat(entries[index:], entries[:index])
+		if !noChange {
+			if err := changeDirectory(rotated[0], false); err != nil {
+				fail(fmt.Sprintf("%s: %v", rotated[0], describePathError(err)))
+				return
+			}
+		}
+		shell.directoryStack = rotated[1:]
+
+	default:
+		target := words[0]
+		if noChange {
+			absPath, _ := absolutePath(target)
+			shell.directoryStack = append([]string{absPath}, shell.directoryStack...)
+			break
+		}
+		if err := changeDirectory(target, false); err != nil {
+			fail(fmt.Sprintf("%s: %v", target, describePathError(err)))
+			return
+		}
+		shell.directoryStack = append([]string{entries[0]}, shell.directoryStack...)
+	}
+
+	outputStream(strings.NewReader(formatDirs(false, false, false)), redirectionTargets, false)
+}
+
+func handlePopd(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	noChange := false
+	if len(words) > 0 && words[0] == "-n" {
+		noChange = true
+		words = words[1:]
+	}
+
+	fail := func(message string) {
+		outputStream(strings.NewReader("popd: "+message+"\n"), redirectionTargets, true)
+		shell.exitStatus = 1
+	}
+
+	if len(shell.directoryStack) == 0 {
+		fail("directory stack empty")
+		return
+	}
+
+	index := 0
+	if len(words) > 0 {
+		var ok bool
+		index, ok = stackIndex(words[0], len(dirsEntries()))
+		if !ok {
+			fail(fmt.Sprintf("%s: directory stack index out of range", words[0]))
+			return
+		}
+	}
+
+	if index == 0 && !noChange {
+		if err := changeDirectory(shell.directoryStack[0], false); err != nil {
+			fail(fmt.Sprintf("%s: %v", shell.directoryStack[0], describePathError(err)))
+			return
+		}
+		shell.directoryStack = shell.directoryStack[1:]
+	} else {
+		// with -n the current directory stays, so the first saved entry goes
+		if index == 0 {
+			index = 1
+		}
+		shell.directoryStack = append(shell.directoryStack[:index-1], shell.directoryStack[index:]...)
+	}
+
+	outputStream(strings.NewReader(formatDirs(false, false, false)), redirectionTargets, false)
+}
+
+// expandTilde resolves a tilde prefix such as ~, ~user, ~+, ~- or ~N
+// (the Nth entry of the directory stack). It reports false when the
+// prefix does not name anything, in which case it is left untouched.
+func expandTilde(prefix string) (string, bool) {
+	name := strings.TrimPrefix(prefix, "~")
+
+	switch name {
+	case "":
+		home := os.Getenv("HOME")
+		return home, home != ""
+	case "+":
+		return currentDirectory(), true
+	case "-":
+		oldPWD := os.Getenv("OLDPWD")
+		return oldPWD, oldPWD != ""
+	}
+
+	entries := dirsEntries()
+	if isDigit(name[0]) {
+		name = "+" + name
+	}
+	if index, ok := stackIndex(name, len(entries)); ok {
+		return entries[index], true
+	}
+	if name[0] == '+' || name[0] == '-' {
+		return "", false
+	}
+
+	account, err := user.Lookup(name)
+	if err != nil {
+		return "", false
+	}
+	return account.HomeDir, true
+}
+
+// expandTildeAt expands the tilde prefix starting at index and returns the
+// expansion and the index of the first character after the prefix.
+func expandTildeAt(input string, index int) (value string, end int) {
+	end = index + 1
+	for end < len(input) && !strings.ContainsRune("/ \t\n|;&<>'\"", rune(input[end])) {
+		end++
+	}
+
+	prefix := input[index:end]
+	if expanded, ok := expandTilde(prefix); ok {
+		return expanded, end
+	}
+	return prefix, end
+}
+
+func describePathError(err error) string {
+	switch {
+	case os.IsNotExist(err):
+		return "No such file or directory"
+	case os.IsPermission(err):
+		return "Permission denied"
+	case errors.Is(err, errNotADirectory):
+		return errNotADirectory.Error()
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	return err.Error()
+}
+
+func isSameFile(first, second string) bool {
+	firstInfo, err := os.Stat(first)
+	if err != nil {
+		return false
+	}
+	secondInfo, err := os.Stat(second)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(firstInfo, secondInfo)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// runCaptured runs a command line and returns what it printed on stdout.
+func runCaptured(line string) string {
+	output, _ := io.ReadAll(captureOutput(func() { runCommandLine(line) }))
+	return string(output)
+}
+
+func TestDirectoryStack(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	root := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		os.Mkdir(filepath.Join(root, name), 0755)
+	}
+	a, b, c := filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "c")
+
+	t.Setenv("HOME", "/nonexistent")
+	shell.workingDirectory = root
+	shell.directoryStack = nil
+
+	// each step runs a line and checks where the shell is and what the
+	// stack holds afterwards
+	steps := []struct {
+		line   string
+		output string
+		cwd    string
+		stack  []string
+	}{
+		{line: "pushd a", output: a + " " + root + "\n", cwd: a, stack: []string{root}},
+		{line: "pushd ../b", output: b + " " + a + " " + root + "\n", cwd: b, stack: []string{a, root}},
+		{line: "pushd", output: a + " " + b + " " + root + "\n", cwd: a, stack: []string{b, root}},
+		{line: "pushd +2", output: root + " " + a + " " + b + "\n", cwd: root, stack: []string{a, b}},
+		{line: "pushd -n c", output: root + " " + c + " " + a + " " + b + "\n", cwd: root, stack: []string{c, a, b}},
+		{line: "dirs -v", output: " 0  " + root + "\n 1  " + c + "\n 2  " + a + "\n 3  " + b + "\n", cwd: root, stack: []string{c, a, b}},
+		{line: "dirs +1", output: c + "\n", cwd: root, stack: []string{c, a, b}},
+		{line: "popd +1", output: root + " " + a + " " + b + "\n", cwd: root, stack: []string{a, b}},
+		{line: "popd", output: a + " " + b + "\n", cwd: a, stack: []string{b}},
+		{line: "popd -n", output: a + "\n", cwd: a, stack: []string{}},
+		{line: "popd", cwd: a, stack: []string{}},
+	}
+
+	for _, step := range steps {
+		output := runCaptured(step.line)
+		if output != step.output {
+			t.Errorf("%q printed %q, want %q", step.line, output, step.output)
+		}
+		if shell.workingDirectory != step.cwd || !slices.Equal(shell.directoryStack, step.stack) {
+			t.Fatalf("after %q the shell is in %s with the stack %q, want %s with %q",
+				step.line, shell.workingDirectory, shell.directoryStack, step.cwd, step.stack)
+		}
+	}
+	if shell.exitStatus != 1 {
+		t.Errorf("popd on an empty stack exited with %d, want 1", shell.exitStatus)
+	}
+}
+
+func TestCDPathAndOldPWD(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	root := t.TempDir()
+	projects := filepath.Join(root, "projects")
+	os.MkdirAll(filepath.Join(projects, "shell"), 0755)
+	os.MkdirAll(filepath.Join(root, "home", "shell"), 0755)
+
+	home := filepath.Join(root, "home")
+	shell.workingDirectory = home
+	t.Setenv("CDPATH", ":"+projects)
+
+	// the empty CDPATH entry is the current directory, which comes first,
+	// and like bash the directory found is printed
+	if output := runCaptured("cd shell"); output != filepath.Join(home, "shell")+"\n" {
+		t.Errorf("cd shell printed %q", output)
+	}
+
+	shell.workingDirectory = root
+	if output := runCaptured("cd shell"); output != filepath.Join(projects, "shell")+"\n" {
+		t.Errorf("cd shell from %s printed %q, want the CDPATH entry", root, output)
+	}
+	if got := os.Getenv("OLDPWD"); got != root {
+		t.Errorf("OLDPWD = %q, want %q", got, root)
+	}
+
+	// ./ never looks at CDPATH
+	shell.workingDirectory = root
+	runCaptured("cd ./shell")
+	if shell.workingDirectory != root || shell.exitStatus != 1 {
+		t.Errorf("cd ./shell moved to %s with status %d", shell.workingDirectory, shell.exitStatus)
+	}
+
+	runCaptured("cd projects")
+	if output := runCaptured("cd -"); output != root+"\n" || shell.workingDirectory != root {
+		t.Errorf("cd - printed %q and moved to %s, want %s", output, shell.workingDirectory, root)
+	}
+	if output := runCaptured("cd -"); !strings.HasSuffix(output, "projects\n") {
+		t.Errorf("a second cd - printed %q", output)
+	}
+}
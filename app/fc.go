@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var errHistoryRange = errors.New("history specification out of range")
+
+type fcOptions struct {
+	list      bool
+	noNumbers bool
+	reverse   bool
+	// substitute is set by -s and by -e -, which run entries without editing
+	substitute bool
+	editor     string
+	// replacements are the old=new words given to -s
+	replacements []string
+	first, last  string
+}
+
+func handleFc(history *historyCache, args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	fail := func(message string, status int) {
+		outputStream(strings.NewReader("fc: "+message+"\n"), redirectionTargets, true)
+		shell.exitStatus = status
+	}
+
+	options, err := parseFcOptions(filterAndJoinArgs(args[1:]))
+	if err != nil {
+		fail(err.Error(), 2)
+		return
+	}
+
+	// like bash, the fc line itself is not one of the entries it works on
+	// and it is replaced by the commands that run
+	entries := history.memory
+	if shell.lastRecorded && len(entries) > 0 {
+		entries = entries[:len(entries)-1]
+	}
+
+	if options.list {
+		listing, err := fcListing(history, entries, options)
+		if err != nil {
+			fail(err.Error(), 1)
+			return
+		}
+		outputStream(strings.NewReader(listing), redirectionTargets, false)
+		return
+	}
+
+	if options.last == "" {
+		options.last = options.first
+	}
+	if options.first == "" {
+		options.first, options.last = "-1", "-1"
+	}
+
+	first, err := fcPosition(history, entries, options.first, false)
+	if err != nil {
+		fail(err.Error(), 1)
+		return
+	}
+
+	var commands string
+
+	if options.substitute {
+		commands = entries[first].line
+		for _, replacement := range options.replacements {
+			old, new, _ := strings.Cut(replacement, "=")
+			if old != "" {
+				commands = strings.ReplaceAll(commands, old, new)
+			}
+		}
+	} else {
+		last, err := fcPosition(history, entries, options.last, false)
+		if err != nil {
+			fail(err.Error(), 1)
+			return
+		}
+
+		var lines []string
+		if first <= last {
+			for index := first; index <= last; index++ {
+				lines = append(lines, entries[index].line)
+			}
+		} else {
+			for index := first; index >= last; index-- {
+				lines = append(lines, entries[index].line)
+			}
+		}
+
+		commands, err = editInEditor(options.editor, strings.Join(lines, "\n")+"\n")
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// the editor already told the user what went wrong
+			shell.exitStatus = exitStatusOf(err)
+			return
+		}
+		if err != nil {
+			fail(err.Error(), exitStatusOf(err))
+			return
+		}
+	}
+
+	history.dropCurrentLine()
+
+	commands = strings.TrimSpace(commands)
+	if commands == "" {
+		history.syncReadline()
+		return
+	}
+
+	// the commands are kept like a line typed at the prompt would be
+	shell.lastRecorded = history.record(commands)
+	if !shell.lastRecorded {
+		history.syncReadline()
+	}
+	fmt.Printf("%s\n", commands)
+	runCommandLine(commands)
+}
+
+func parseFcOptions(words []string) (options fcOptions, err error) {
+	for len(words) > 0 {
+		word := words[0]
+		if word == "--" {
+			words = words[1:]
+			break
+		}
+		// -5 is an offset into the history, not an option
+		if len(word) < 2 || word[0] != '-' || isDigit(word[1]) {
+			break
+		}
+		words = words[1:]
+
+		for i := 1; i < len(word); i++ {
+			switch word[i] {
+			case 'l':
+				options.list = true
+			case 'n':
+				options.noNumbers = true
+			case 'r':
+				options.reverse = true
+			case 's':
+				options.substitute = true
+			case 'e':
+				// the editor is the rest of the word or the next word
+				editor := word[i+1:]
+				if editor == "" {
+					if len(words) == 0 {
+						return options, fmt.Errorf("-e: option requires an argument")
+					}
+					editor, words = words[0], words[1:]
+				}
+				if editor == "-" {
+					options.substitute = true
+				} else {
+					options.editor = editor
+				}
+				i = len(word)
+			default:
+				return options, fmt.Errorf("-%c: invalid option", word[i])
+			}
+		}
+	}
+
+	if options.substitute {
+		for len(words) > 0 && strings.Contains(words[0], "=") {
+			options.replacements = append(options.replacements, words[0])
+			words = words[1:]
+		}
+	}
+
+	if len(words) > 0 {
+		options.first = words[0]
+	}
+	if len(words) > 1 && !options.substitute {
+		options.last = words[1]
+	}
+	return options, nil
+}
+
+// fcPosition finds the entry that spec refers to: a history number, a
+// negative offset from the last entry, or the most recent entry starting
+// with spec. Listing clamps numbers that fall outside the history.
+func fcPosition(history *historyCache, entries []historyEntry, spec string, clamp bool) (int, error) {
+	if len(entries) == 0 {
+		return 0, errHistoryRange
+	}
+
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 0 {
+			n += len(entries)
+		} else {
+			n -= history.base
+		}
+
+		if clamp {
+			return min(max(n, 0), len(entries)-1), nil
+		}
+		if n < 0 || n >= len(entries) {
+			return 0, errHistoryRange
+		}
+		return n, nil
+	}
+
+	for index := len(entries) - 1; index >= 0; index-- {
+		if strings.HasPrefix(entries[index].line, spec) {
+			return index, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: no command found", spec)
+}
+
+// fcListing handles fc -l, which shows the last 16 entries by default.
+func fcListing(history *historyCache, entries []historyEntry, options fcOptions) (string, error) {
+	if options.first == "" {
+		options.first = "-16"
+	}
+	if options.last == "" {
+		options.last = "-1"
+	}
+
+	first, err := fcPosition(history, entries, options.first, true)
+	if err != nil {
+		return "", err
+	}
+	last, err := fcPosition(history, entries, options.last, true)
+	if err != nil {
+		return "", err
+	}
+
+	reverse := options.reverse
+	if first > last {
+		first, last = last, first
+		reverse = !reverse
+	}
+
+	var builder strings.Builder
+	for step := 0; step <= last-first; step++ {
+		index := first + step
+		if reverse {
+			index = last - step
+		}
+
+		if !options.noNumbers {
+			builder.WriteString(strconv.Itoa(history.base + index))
+		}
+		builder.WriteString(fmt.Sprintf("\t %s\n", entries[index].line))
+	}
+
+	return builder.String(), nil
+}
+
+// editInEditor opens text in editor, or in FCEDIT, EDITOR or vi when editor
+// is empty, and returns the file as the editor left it. A failing editor
+// returns its exit error so the commands are not run.
+func editInEditor(editor string, text string) (string, error) {
+	for _, candidate := range []string{editor, os.Getenv("FCEDIT"), os.Getenv("EDITOR"), "vi"} {
+		if candidate != "" {
+			editor = candidate
+			break
+		}
+	}
+
+	// the editor may come with arguments of its own, as in EDITOR="code -w"
+	editorWords := filterAndJoinArgs(SplitArgs(editor))
+	if len(editorWords) == 0 {
+		return "", fmt.Errorf("%s: not found", editor)
+	}
+
+	editorPath, err := lookupCommand(editorWords[0])
+	if err != nil {
+		return "", fmt.Errorf("%s: not found", editorWords[0])
+	}
+
+	file, err := os.CreateTemp("", "fc-*.sh")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(text)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command(editorPath, append(editorWords[1:], file.Name())...)
+	cmd.Dir = currentDirectory()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	edited, err := os.ReadFile(file.Name())
+	return string(edited), err
+}
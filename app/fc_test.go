@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeEditor creates an executable script that fc can use as its editor.
+func writeEditor(t *testing.T, name, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFcListing(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.history = &historyCache{}
+	t.Setenv("HISTCONTROL", "")
+	t.Setenv("HISTIGNORE", "")
+
+	typeLines("echo one", "echo two", "echo three")
+
+	listings := map[string]string{
+		"fc -l":           "0\t echo one\n1\t echo two\n2\t echo three\n",
+		"fc -l -2":        "1\t echo two\n2\t echo three\n",
+		"fc -ln 0 1":      "\t echo one\n\t echo two\n",
+		"fc -lr echo":     "2\t echo three\n",
+		"fc -l 2 0":       "2\t echo three\n1\t echo two\n0\t echo one\n",
+		"fc -l -100 100":  "0\t echo one\n1\t echo two\n2\t echo three\n",
+		"fc -l echo\\ tw": "1\t echo two\n2\t echo three\n",
+	}
+	for line, want := range listings {
+		// the fc line is recorded, but it is not part of what it lists
+		if got := typeLines(line); got != want {
+			t.Errorf("%q printed %q, want %q", line, got, want)
+		}
+		shell.history.dropCurrentLine()
+	}
+}
+
+func TestFcRerunsEntries(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.history = &historyCache{}
+	t.Setenv("HISTCONTROL", "")
+	t.Setenv("HISTIGNORE", "")
+
+	typeLines("echo one", "echo two")
+
+	// fc -s runs the entry again with the replacements, and the new line
+	// takes the place of the fc line in the history
+	if output := typeLines("fc -s one=1 echo\\ o"); output != "echo 1\n1\n" {
+		t.Errorf("fc -s printed %q", output)
+	}
+	if output := typeLines("fc -e - 1=2"); output != "echo 2\n2\n" {
+		t.Errorf("fc -e - printed %q", output)
+	}
+
+	t.Setenv("FCEDIT", writeEditor(t, "edit", `sed -i 's/echo/echo edited/' "$1"`))
+	if output := typeLines("fc 0 1"); output != "echo edited one\necho edited two\nedited one\nedited two\n" {
+		t.Errorf("fc with an editor printed %q", output)
+	}
+
+	want := []string{"echo one", "echo two", "echo 1", "echo 2", "echo edited one\necho edited two"}
+	if got := historyLines(shell.history); !slices.Equal(got, want) {
+		t.Errorf("the history is %q, want %q", got, want)
+	}
+
+	// a failing editor runs nothing and fc exits with its status
+	if output := typeLines("fc -e " + writeEditor(t, "fails", "exit 3")); output != "" || shell.exitStatus != 3 {
+		t.Errorf("fc with a failing editor printed %q and exited with %d", output, shell.exitStatus)
+	}
+
+	if output := typeLines("fc -s nosuch"); output != "" || shell.exitStatus != 1 {
+		t.Errorf("fc -s nosuch printed %q and exited with %d", output, shell.exitStatus)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitStatusTimeout bounds how long the prompt waits for git status. In a
+// repository too large to answer in time only the branch is shown.
+const gitStatusTimeout = 200 * time.Millisecond
+
+// gitState is what the \g prompt escape shows about the repository of the
+// current directory.
+type gitState struct {
+	// branch is the checked out branch, or the abbreviated commit when
+	// HEAD is detached
+	branch string
+	// operation is a merge, rebase, cherry-pick or bisect in progress
+	operation string
+	// timedOut is set when git status did not answer in time
+	timedOut                    bool
+	staged, unstaged, untracked bool
+	ahead, behind               int
+}
+
+// gitPromptSegment describes the repository of the current directory for
+// \g, formatted with GIT_PROMPT_FORMAT, " (%s)" by default, so nothing at
+// all is shown outside a repository.
+func gitPromptSegment() string {
+	gitDirectory, ok := findGitDirectory(currentDirectory())
+	if !ok {
+		return ""
+	}
+
+	state := readGitHead(gitDirectory)
+	if state.branch == "" {
+		return ""
+	}
+	readGitStatus(&state)
+
+	format, ok := os.LookupEnv("GIT_PROMPT_FORMAT")
+	if !ok {
+		format = " (%s)"
+	}
+	return strings.ReplaceAll(format, "%s", state.String())
+}
+
+func (state gitState) String() string {
+	var builder strings.Builder
+	builder.WriteString(state.branch)
+	if state.operation != "" {
+		builder.WriteString("|" + state.operation)
+	}
+
+	var flags string
+	if state.unstaged {
+		flags += "*"
+	}
+	if state.staged {
+		flags += "+"
+	}
+	if state.untracked {
+		flags += "%"
+	}
+	if state.timedOut {
+		// the state of the files is unknown
+		flags += "?"
+	}
+	if flags != "" {
+		builder.WriteString(" " + flags)
+	}
+
+	if state.ahead > 0 {
+		builder.WriteString(fmt.Sprintf(" ↑%d", state.ahead))
+	}
+	if state.behind > 0 {
+		builder.WriteString(fmt.Sprintf(" ↓%d", state.behind))
+	}
+	return builder.String()
+}
+
+// findGitDirectory looks for the .git of directory or of the closest
+// directory above it. A .git file, as in worktrees and submodules, points
+// to the real one.
+func findGitDirectory(directory string) (string, bool) {
+	for {
+		candidate := filepath.Join(directory, ".git")
+		if info, err := os.Stat(candidate); err == nil {
+			if info.IsDir() {
+				return candidate, true
+			}
+
+			content, err := os.ReadFile(candidate)
+			if err != nil {
+				return "", false
+			}
+			gitDirectory, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir: ")
+			if !ok {
+				return "", false
+			}
+			if !filepath.IsAbs(gitDirectory) {
+				gitDirectory = filepath.Join(directory, gitDirectory)
+			}
+			return gitDirectory, true
+		}
+
+		parent := filepath.Dir(directory)
+		if parent == directory {
+			return "", false
+		}
+		directory = parent
+	}
+}
+
+// readGitHead reads the branch from HEAD and the operation in progress
+// from the files git leaves in the git directory while it lasts.
+func readGitHead(gitDirectory string) gitState {
+	var state gitState
+
+	head, err := os.ReadFile(filepath.Join(gitDirectory, "HEAD"))
+	if err != nil {
+		return state
+	}
+
+	reference := strings.TrimSpace(string(head))
+	if branch, ok := strings.CutPrefix(reference, "ref: "); ok {
+		state.branch = strings.TrimPrefix(branch, "refs/heads/")
+	} else if len(reference) >= 7 {
+		state.branch = reference[:7] + "..."
+	}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(gitDirectory, name))
+		return err == nil
+	}
+
+	switch {
+	case exists("rebase-merge") || exists("rebase-apply"):
+		state.operation = "REBASE"
+	case exists("MERGE_HEAD"):
+		state.operation = "MERGING"
+	case exists("CHERRY_PICK_HEAD"):
+		state.operation = "CHERRY-PICKING"
+	case exists("REVERT_HEAD"):
+		state.operation = "REVERTING"
+	case exists("BISECT_LOG"):
+		state.operation = "BISECTING"
+	}
+
+	return state
+}
+
+// readGitStatus fills in the state of the files and of the upstream branch
+// from git status, which only looks at the local repository. It gives up
+// after gitStatusTimeout.
+func readGitStatus(state *gitState) {
+	gitPath, err := lookupCommand("git")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gitStatusTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, gitPath, "--no-optional-locks", "status", "--porcelain=v2", "--branch")
+	cmd.Dir = currentDirectory()
+	cmd.WaitDelay = gitStatusTimeout
+
+	output, err := cmd.Output()
+	if err != nil {
+		state.timedOut = ctx.Err() == context.DeadlineExceeded
+		return
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "#":
+			// # branch.ab +ahead -behind
+			if fields[1] == "branch.ab" && len(fields) == 4 {
+				fmt.Sscanf(fields[2], "+%d", &state.ahead)
+				fmt.Sscanf(fields[3], "-%d", &state.behind)
+			}
+		case "1", "2":
+			// XY, with a dot for no change, staged first
+			state.staged = state.staged || fields[1][0] != '.'
+			state.unstaged = state.unstaged || fields[1][1] != '.'
+		case "u":
+			state.unstaged = true
+		case "?":
+			state.untracked = true
+		}
+	}
+}
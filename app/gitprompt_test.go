@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitStateString(t *testing.T) {
+	tests := []struct {
+		state gitState
+		want  string
+	}{
+		{state: gitState{branch: "main"}, want: "main"},
+		{state: gitState{branch: "main", unstaged: true, staged: true, untracked: true}, want: "main *+%"},
+		{state: gitState{branch: "topic", operation: "REBASE", ahead: 2, behind: 1}, want: "topic|REBASE ↑2 ↓1"},
+		{state: gitState{branch: "huge", timedOut: true}, want: "huge ?"},
+	}
+
+	for _, test := range tests {
+		if got := test.state.String(); got != test.want {
+			t.Errorf("%+v.String() = %q, want %q", test.state, got, test.want)
+		}
+	}
+}
+
+func TestReadGitHead(t *testing.T) {
+	root := t.TempDir()
+	gitDirectory := filepath.Join(root, "repo", ".git")
+	os.MkdirAll(gitDirectory, 0755)
+	nested := filepath.Join(root, "repo", "a", "b")
+	os.MkdirAll(nested, 0755)
+
+	// a worktree has a .git file pointing to its git directory
+	worktree := filepath.Join(root, "worktree")
+	os.MkdirAll(worktree, 0755)
+	os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: ../repo/.git\n"), 0644)
+
+	for _, directory := range []string{nested, worktree} {
+		found, ok := findGitDirectory(directory)
+		if !ok || filepath.Clean(found) != gitDirectory {
+			t.Errorf("findGitDirectory(%s) = %s, %v, want %s", directory, found, ok, gitDirectory)
+		}
+	}
+	if found, ok := findGitDirectory(root); ok {
+		t.Errorf("findGitDirectory(%s) found %s outside any repository", root, found)
+	}
+
+	write := func(name, content string) {
+		os.WriteFile(filepath.Join(gitDirectory, name), []byte(content), 0644)
+	}
+
+	write("HEAD", "ref: refs/heads/feature/x\n")
+	if state := readGitHead(gitDirectory); state.branch != "feature/x" || state.operation != "" {
+		t.Errorf("on a branch readGitHead = %+v", state)
+	}
+
+	write("HEAD", "0123456789abcdef0123456789abcdef01234567\n")
+	write("MERGE_HEAD", "")
+	if state := readGitHead(gitDirectory); state.branch != "0123456..." || state.operation != "MERGING" {
+		t.Errorf("detached while merging readGitHead = %+v", state)
+	}
+
+	os.Mkdir(filepath.Join(gitDirectory, "rebase-merge"), 0755)
+	if state := readGitHead(gitDirectory); state.operation != "REBASE" {
+		t.Errorf("while rebasing readGitHead = %+v", state)
+	}
+}
+
+func TestGitPromptSegment(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	repository := t.TempDir()
+	shell.workingDirectory = repository
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@t", "-c", "init.defaultBranch=main"}, args...)...)
+		cmd.Dir = repository
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+
+	git("init", "-q")
+	os.WriteFile(filepath.Join(repository, "tracked"), []byte("1"), 0644)
+	git("add", "tracked")
+	git("commit", "-q", "-m", "first")
+
+	unsetenv(t, "GIT_PROMPT_FORMAT")
+	if got := gitPromptSegment(); got != " (main)" {
+		t.Errorf("in a clean repository \\g is %q", got)
+	}
+
+	os.WriteFile(filepath.Join(repository, "tracked"), []byte("2"), 0644)
+	os.WriteFile(filepath.Join(repository, "new"), nil, 0644)
+	t.Setenv("GIT_PROMPT_FORMAT", "[%s]")
+	if got := gitPromptSegment(); got != "[main *%]" {
+		t.Errorf("with changes \\g is %q", got)
+	}
+
+	shell.workingDirectory = t.TempDir()
+	if got := gitPromptSegment(); got != "" {
+		t.Errorf("outside a repository \\g is %q", got)
+	}
+}
+
+func TestRunPromptCommand(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.workingDirectory = t.TempDir()
+
+	// $? is the status of the command before the prompt, for PS1 as well
+	// as inside PROMPT_COMMAND, and it is left as it was
+	shell.exitStatus = 4
+	t.Setenv("PROMPT_COMMAND", "echo $? > status; false")
+	runPromptCommand()
+	if content, _ := os.ReadFile(filepath.Join(shell.workingDirectory, "status")); string(content) != "4\n" {
+		t.Errorf("PROMPT_COMMAND saw $? as %q", content)
+	}
+	if shell.exitStatus != 4 {
+		t.Errorf("PROMPT_COMMAND changed $? to %d", shell.exitStatus)
+	}
+
+	// an array runs each element
+	unsetenv(t, "PROMPT_COMMAND")
+	shellArrays["PROMPT_COMMAND"] = []string{"echo one >> log", "", "echo two >> log"}
+	runPromptCommand()
+	if content, _ := os.ReadFile(filepath.Join(shell.workingDirectory, "log")); string(content) != "one\ntwo\n" {
+		t.Errorf("the PROMPT_COMMAND array wrote %q", content)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// commandIndex knows the executables of every PATH directory. A directory
+// is listed again only when its modification time changes, which happens
+// whenever a file is added to it, removed from it or renamed in it.
+type commandIndex struct {
+	// path is the PATH the directories were indexed for
+	path        string
+	directories map[string]*indexedDirectory
+	// hashed are the locations remembered for the commands that were run,
+	// which hash lists and hash -r forgets
+	hashed map[string]*hashedCommand
+}
+
+type indexedDirectory struct {
+	modTime     time.Time
+	executables map[string]bool
+}
+
+type hashedCommand struct {
+	path string
+	hits int
+	// pinned entries come from hash -p and are used without checking them
+	pinned bool
+}
+
+var commandTable = &commandIndex{
+	directories: map[string]*indexedDirectory{},
+	hashed:      map[string]*hashedCommand{},
+}
+
+// refresh brings the index up to date with PATH. Only the directories whose
+// modification time changed are read again.
+func (index *commandIndex) refresh() {
+	path := os.Getenv("PATH")
+	if path != index.path {
+		// like bash, setting PATH forgets every remembered location
+		index.path = path
+		clear(index.directories)
+		clear(index.hashed)
+	}
+
+	for _, directory := range filepath.SplitList(path) {
+		info, err := os.Stat(directory)
+		if err != nil || !info.IsDir() {
+			delete(index.directories, directory)
+			continue
+		}
+
+		if indexed, ok := index.directories[directory]; ok && indexed.modTime.Equal(info.ModTime()) {
+			continue
+		}
+		index.directories[directory] = &indexedDirectory{
+			modTime:     info.ModTime(),
+			executables: listExecutables(directory),
+		}
+	}
+}
+
+// listExecutables reads the regular files of directory that may be run.
+// unix.Access is used instead of exec.LookPath, which costs several times
+// more per file.
+func listExecutables(directory string) map[string]bool {
+	executables := map[string]bool{}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return executables
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(directory, entry.Name())
+		if entry.IsDir() {
+			continue
+		}
+		// symlinks are followed so a link to a directory is left out
+		if entry.Type()&os.ModeSymlink != 0 {
+			if info, err := os.Stat(path); err != nil || info.IsDir() {
+				continue
+			}
+		}
+		if unix.Access(path, unix.X_OK) == nil {
+			executables[entry.Name()] = true
+		}
+	}
+
+	return executables
+}
+
+// find returns the path name runs from, trying the remembered location
+// before searching PATH.
+func (index *commandIndex) find(name string) (string, bool) {
+	if os.Getenv("PATH") == index.path {
+		if hashed, ok := index.hashed[name]; ok {
+			if hashed.pinned || unix.Access(hashed.path, unix.X_OK) == nil {
+				return hashed.path, true
+			}
+			// the program moved or was removed since it was remembered
+			delete(index.hashed, name)
+		}
+	}
+
+	index.refresh()
+
+	if directory := index.location(name); directory != "" {
+		return filepath.Join(directory, name), true
+	}
+	return "", false
+}
+
+// location returns the PATH directory name is found in as of the last
+// refresh, without looking at the directories again.
+func (index *commandIndex) location(name string) string {
+	for _, directory := range filepath.SplitList(index.path) {
+		if indexed, ok := index.directories[directory]; ok && indexed.executables[name] {
+			return directory
+		}
+	}
+	return ""
+}
+
+// remember records that name is about to run from path.
+func (index *commandIndex) remember(name, path string) {
+	if strings.Contains(name, "/") {
+		return
+	}
+
+	hashed, ok := index.hashed[name]
+	if !ok {
+		hashed = &hashedCommand{path: path}
+		index.hashed[name] = hashed
+	}
+	hashed.hits++
+}
+
+// names lists every executable in PATH.
+func (index *commandIndex) names() []string {
+	index.refresh()
+
+	var names []string
+	for _, indexed := range index.directories {
+		for name := range indexed.executables {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func handleHash(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	fail := func(message string, status int) {
+		outputStream(strings.NewReader("hash: "+message+"\n"), redirectionTargets, true)
+		shell.exitStatus = status
+	}
+
+	var cleared, reusable, forget, print bool
+	pinnedPath := ""
+
+	for len(words) > 0 && len(words[0]) > 1 && words[0][0] == '-' {
+		flag := words[0]
+		words = words[1:]
+		if flag == "--" {
+			break
+		}
+
+		switch flag {
+		case "-r":
+			clear(commandTable.hashed)
+			clear(commandTable.directories)
+			cleared = true
+		case "-l":
+			reusable = true
+		case "-d":
+			forget = true
+		case "-t":
+			print = true
+		case "-p":
+			if len(words) == 0 {
+				fail("-p: option requires an argument", 2)
+				return
+			}
+			pinnedPath, words = words[0], words[1:]
+		default:
+			fail(fmt.Sprintf("%s: invalid option", flag), 2)
+			return
+		}
+	}
+
+	if len(words) == 0 {
+		if pinnedPath != "" || forget || print {
+			fail("option requires a name", 2)
+			return
+		}
+		// hash -r on its own prints nothing
+		if cleared && !reusable {
+			return
+		}
+		outputStream(strings.NewReader(formatHashTable(reusable)), redirectionTargets, false)
+		return
+	}
+
+	var result strings.Builder
+
+	for _, name := range words {
+		switch {
+		case pinnedPath != "":
+			// the index catches up with PATH first, a change of PATH found
+			// later would forget the entry
+			commandTable.refresh()
+			commandTable.hashed[name] = &hashedCommand{path: pinnedPath, pinned: true}
+
+		case forget:
+			if _, ok := commandTable.hashed[name]; !ok {
+				fail(fmt.Sprintf("%s: not found", name), 1)
+				continue
+			}
+			delete(commandTable.hashed, name)
+
+		case print:
+			hashed, ok := commandTable.hashed[name]
+			if !ok {
+				fail(fmt.Sprintf("%s: not found", name), 1)
+				continue
+			}
+			if len(words) > 1 {
+				result.WriteString(name + "\t")
+			}
+			result.WriteString(hashed.path + "\n")
+
+		default:
+			// builtins are never hashed
+			if slices.Contains(builtinTools, name) {
+				continue
+			}
+			path, ok := commandTable.find(name)
+			if !ok {
+				fail(fmt.Sprintf("%s: not found", name), 1)
+				continue
+			}
+			if _, ok := commandTable.hashed[name]; !ok {
+				commandTable.hashed[name] = &hashedCommand{path: path}
+			}
+		}
+	}
+
+	outputStream(strings.NewReader(result.String()), redirectionTargets, false)
+}
+
+// formatHashTable lists the remembered commands like bash does, or as hash
+// -p commands that recreate them.
+func formatHashTable(reusable bool) string {
+	if len(commandTable.hashed) == 0 {
+		return "hash: hash table empty\n"
+	}
+
+	var names []string
+	for name := range commandTable.hashed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	if !reusable {
+		builder.WriteString("hits\tcommand\n")
+	}
+	for _, name := range names {
+		hashed := commandTable.hashed[name]
+		if reusable {
+			builder.WriteString(fmt.Sprintf("hash -p %s %s\n", hashed.path, name))
+		} else {
+			builder.WriteString(fmt.Sprintf("%4d\t%s\n", hashed.hits, hashed.path))
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// installProgram writes a script printing text into directory and moves
+// the directory's modification time on, as a later install would.
+func installProgram(t *testing.T, directory, name, text string) {
+	t.Helper()
+	path := filepath.Join(directory, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho "+text+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Duration(len(text)) * time.Second)
+	os.Chtimes(directory, later, later)
+}
+
+func TestHashFollowsPrograms(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	first, second := t.TempDir(), t.TempDir()
+	t.Setenv("PATH", first+string(os.PathListSeparator)+second+string(os.PathListSeparator)+"/bin:/usr/bin")
+	shell.workingDirectory = t.TempDir()
+
+	installProgram(t, second, "tool", "second")
+	if got := runCaptured("tool"); got != "second\n" {
+		t.Fatalf("tool printed %q", got)
+	}
+	if got := runCaptured("hash -t tool"); got != filepath.Join(second, "tool")+"\n" {
+		t.Errorf("hash -t tool printed %q", got)
+	}
+
+	// like bash, the remembered location wins over a new one earlier in
+	// PATH until hash -r
+	installProgram(t, first, "tool", "first")
+	if got := runCaptured("tool"); got != "second\n" {
+		t.Errorf("after installing an earlier tool, tool printed %q", got)
+	}
+	runCaptured("hash -r")
+	if got := runCaptured("tool"); got != "first\n" {
+		t.Errorf("after hash -r, tool printed %q", got)
+	}
+
+	// a binary replaced in place runs its new version
+	installProgram(t, first, "tool", "rebuilt")
+	if got := runCaptured("tool"); got != "rebuilt\n" {
+		t.Errorf("after rebuilding tool, it printed %q", got)
+	}
+
+	// a removed binary is looked up again instead of failing
+	os.Remove(filepath.Join(first, "tool"))
+	if got := runCaptured("tool"); got != "second\n" {
+		t.Errorf("after removing the hashed tool, tool printed %q", got)
+	}
+
+	// a program installed after the index was built is found without hash -r
+	installProgram(t, second, "fresh", "new program")
+	if got := runCaptured("fresh"); got != "new program\n" {
+		t.Errorf("a newly installed program printed %q", got)
+	}
+
+	// setting PATH forgets every location, once a command is looked up
+	t.Setenv("PATH", second+string(os.PathListSeparator)+"/bin:/usr/bin")
+	runCaptured("fresh")
+	if got := runCaptured("hash -t tool"); got != "" || shell.exitStatus != 1 {
+		t.Errorf("after changing PATH, hash -t tool printed %q with status %d", got, shell.exitStatus)
+	}
+}
+
+func TestHashBuiltin(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	directory := t.TempDir()
+	t.Setenv("PATH", directory+string(os.PathListSeparator)+"/bin:/usr/bin")
+	installProgram(t, directory, "tool", "tool")
+	runCaptured("hash -r")
+
+	runCaptured("hash tool")
+	runCaptured("hash -p /opt/elsewhere pinned")
+	want := "hash -p /opt/elsewhere pinned\nhash -p " + filepath.Join(directory, "tool") + " tool\n"
+	if got := runCaptured("hash -l"); got != want {
+		t.Errorf("hash -l printed %q, want %q", got, want)
+	}
+
+	// a pinned location is used as it is, even if nothing is there
+	if path, err := lookupCommand("pinned"); err != nil || path != "/opt/elsewhere" {
+		t.Errorf("lookupCommand(pinned) = %q, %v", path, err)
+	}
+
+	runCaptured("hash -d pinned")
+	if _, err := lookupCommand("pinned"); err == nil {
+		t.Errorf("hash -d pinned left the command known")
+	}
+
+	runCaptured("hash nosuchprogram")
+	if shell.exitStatus != 1 {
+		t.Errorf("hash nosuchprogram exited with %d, want 1", shell.exitStatus)
+	}
+	runCaptured("hash -r")
+	if got := runCaptured("hash"); got != "hash: hash table empty\n" {
+		t.Errorf("hash after hash -r printed %q", got)
+	}
+}
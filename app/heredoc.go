@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// hereDocument is a <<WORD redirection of a command line together with
+// the lines after it that make up its body.
+type hereDocument struct {
+	// start and end delimit the <<WORD operator in the text returned by
+	// parseHereDocuments
+	start, end int
+	delimiter  string
+	// stripTabs is set for <<-, which removes the leading tabs of the body
+	// and of the closing line
+	stripTabs bool
+	// expand is set when the delimiter is not quoted, the body then has
+	// its variables expanded
+	expand bool
+	body   string
+}
+
+// parseHereDocuments finds the here-documents of text. Their bodies start
+// on the line after the operator and end with a line holding only the
+// delimiter. The text is returned without the bodies, and complete is
+// false while a body has not ended.
+func parseHereDocuments(text string) (command string, documents []hereDocument, complete bool) {
+	var builder strings.Builder
+	// pending are the documents whose bodies start after the next newline
+	var pending []int
+	scanner := rawScanner{input: text}
+
+	for index := 0; index < len(text); index++ {
+		char := text[index]
+		quoted := scanner.activeQuote != 0 || scanner.escaped
+		scanner.step(index)
+
+		// a <<< here-string has no body
+		if !quoted && strings.HasPrefix(text[index:], "<<<") {
+			builder.WriteString("<<<")
+			index += 2
+			continue
+		}
+
+		if !quoted && strings.HasPrefix(text[index:], "<<") {
+			if document, end, ok := parseHereDocumentOperator(text, index); ok {
+				document.start = builder.Len()
+				builder.WriteString(text[index:end])
+				document.end = builder.Len()
+				documents = append(documents, document)
+				pending = append(pending, len(documents)-1)
+				index = end - 1
+				continue
+			}
+		}
+
+		builder.WriteByte(char)
+		if quoted || char != '\n' || len(pending) == 0 {
+			continue
+		}
+
+		// the bodies follow one another after the line of the operators
+		position := index + 1
+		for _, documentIndex := range pending {
+			body, end, found := readHereDocumentBody(text, position, documents[documentIndex])
+			documents[documentIndex].body = body
+			if !found {
+				return builder.String(), documents, false
+			}
+			position = end
+		}
+		pending = nil
+		index = position - 1
+	}
+
+	return builder.String(), documents, len(pending) == 0
+}
+
+// parseHereDocumentOperator reads the <<WORD or <<-WORD at index and
+// returns the index right after it.
+func parseHereDocumentOperator(text string, index int) (hereDocument, int, bool) {
+	var document hereDocument
+
+	end := index + 2
+	if end < len(text) && text[end] == '-' {
+		document.stripTabs = true
+		end++
+	}
+	for end < len(text) && (text[end] == ' ' || text[end] == '\t') {
+		end++
+	}
+
+	// the delimiter is a word, quoting any part of it leaves the body as
+	// it is
+	start := end
+	var quote byte
+	for end < len(text) && (quote != 0 || !strings.ContainsRune(" \t\n;|&<>()", rune(text[end]))) {
+		switch {
+		case quote != 0 && text[end] == quote:
+			quote = 0
+		case quote == 0 && (text[end] == '\'' || text[end] == '"'):
+			quote = text[end]
+		}
+		end++
+	}
+
+	word := text[start:end]
+	if word == "" {
+		return document, 0, false
+	}
+	document.delimiter = strings.NewReplacer(`'`, "", `"`, "", `\`, "").Replace(word)
+	document.expand = !strings.ContainsAny(word, `'"\`)
+	return document, end, true
+}
+
+// readHereDocumentBody reads the lines of text from position up to the
+// line closing document, and returns them and the index after that line.
+// Without a closing line the body is the rest of text.
+func readHereDocumentBody(text string, position int, document hereDocument) (string, int, bool) {
+	var body strings.Builder
+
+	for position < len(text) {
+		lineEnd := strings.IndexByte(text[position:], '\n')
+		if lineEnd == -1 {
+			lineEnd = len(text)
+		} else {
+			lineEnd += position
+		}
+		line := text[position:lineEnd]
+		position = min(lineEnd+1, len(text))
+
+		if document.stripTabs {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == document.delimiter {
+			return body.String(), position, true
+		}
+		body.WriteString(line + "\n")
+	}
+
+	return body.String(), position, false
+}
+
+// startHereDocuments writes the body of every here-document of line to a
+// temporary file and redirects the input of its command from that file.
+// The returned function removes the files once the command has run.
+func startHereDocuments(line string) (string, func(), error) {
+	command, documents, complete := parseHereDocuments(line)
+	if len(documents) == 0 {
+		return line, func() {}, nil
+	}
+
+	var paths []string
+	cleanup := func() {
+		for _, path := range paths {
+			os.Remove(path)
+		}
+	}
+
+	if !complete {
+		last := documents[len(documents)-1]
+		printErr(fmt.Sprintf("warning: here-document delimited by end-of-file (wanted `%s')\n", last.delimiter))
+	}
+
+	// the operators are replaced from the last one so the positions of
+	// the others stay right
+	for index := len(documents) - 1; index >= 0; index-- {
+		document := documents[index]
+
+		body := document.body
+		if document.expand {
+			body = expandHereDocument(body)
+		}
+
+		file, err := os.CreateTemp("", "here-document-")
+		if err != nil {
+			cleanup()
+			return "", func() {}, err
+		}
+		paths = append(paths, file.Name())
+		_, err = file.WriteString(body)
+		file.Close()
+		if err != nil {
+			cleanup()
+			return "", func() {}, err
+		}
+
+		command = command[:document.start] + " < " + quoteWord(file.Name()) + " " + command[document.end:]
+	}
+
+	return command, cleanup, nil
+}
+
+// expandHereDocument expands the variables of the body of a here-document
+// with an unquoted delimiter. A backslash only escapes $, \ and a newline.
+func expandHereDocument(body string) string {
+	var builder strings.Builder
+
+	for index := 0; index < len(body); index++ {
+		char := body[index]
+
+		switch {
+		case char == '\\' && index+1 < len(body) && strings.ContainsRune(`$\`, rune(body[index+1])):
+			index++
+			builder.WriteByte(body[index])
+		case char == '\\' && index+1 < len(body) && body[index+1] == '\n':
+			index++
+		case char == '$':
+			value, end := expandVariableAt(body, index)
+			builder.WriteString(value)
+			index = end - 1
+		default:
+			builder.WriteByte(char)
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,134 @@
+package main
+
+import "testing"
+
+func TestParseHereDocuments(t *testing.T) {
+	type document struct {
+		delimiter string
+		stripTabs bool
+		expand    bool
+		body      string
+	}
+
+	tests := []struct {
+		name      string
+		text      string
+		command   string
+		documents []document
+		complete  bool
+	}{
+		{
+			name:     "no here-document",
+			text:     "echo a < b",
+			command:  "echo a < b",
+			complete: true,
+		},
+		{
+			name:      "simple",
+			text:      "cat <<EOF\nline 1\nline 2\nEOF",
+			command:   "cat <<EOF\n",
+			documents: []document{{delimiter: "EOF", expand: true, body: "line 1\nline 2\n"}},
+			complete:  true,
+		},
+		{
+			name:      "command after the body",
+			text:      "cat <<EOF | wc -l\nx\nEOF\necho after",
+			command:   "cat <<EOF | wc -l\necho after",
+			documents: []document{{delimiter: "EOF", expand: true, body: "x\n"}},
+			complete:  true,
+		},
+		{
+			name:      "quoted delimiter",
+			text:      "cat <<'END'\n$HOME\nEND",
+			command:   "cat <<'END'\n",
+			documents: []document{{delimiter: "END", body: "$HOME\n"}},
+			complete:  true,
+		},
+		{
+			name:      "partly quoted delimiter",
+			text:      "cat << E\"N\"D\nx\nEND",
+			command:   "cat << E\"N\"D\n",
+			documents: []document{{delimiter: "END", body: "x\n"}},
+			complete:  true,
+		},
+		{
+			name:      "tabs stripped",
+			text:      "cat <<-EOF\n\tindented\n\tEOF",
+			command:   "cat <<-EOF\n",
+			documents: []document{{delimiter: "EOF", stripTabs: true, expand: true, body: "indented\n"}},
+			complete:  true,
+		},
+		{
+			name:    "two documents on one line",
+			text:    "cat <<A; cat <<B\na\nA\nb\nB",
+			command: "cat <<A; cat <<B\n",
+			documents: []document{
+				{delimiter: "A", expand: true, body: "a\n"},
+				{delimiter: "B", expand: true, body: "b\n"},
+			},
+			complete: true,
+		},
+		{
+			name:      "body not ended",
+			text:      "cat <<EOF\nline",
+			command:   "cat <<EOF\n",
+			documents: []document{{delimiter: "EOF", expand: true, body: "line\n"}},
+			complete:  false,
+		},
+		{
+			name:     "operator inside quotes",
+			text:     "echo '<<EOF'",
+			command:  "echo '<<EOF'",
+			complete: true,
+		},
+		{
+			name:     "here-string",
+			text:     "cat <<<word",
+			command:  "cat <<<word",
+			complete: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, documents, complete := parseHereDocuments(test.text)
+			if command != test.command || complete != test.complete {
+				t.Errorf("parseHereDocuments(%q) = %q, complete %v, want %q, complete %v", test.text, command, complete, test.command, test.complete)
+			}
+			if len(documents) != len(test.documents) {
+				t.Fatalf("parseHereDocuments(%q) found %d here-documents, want %d", test.text, len(documents), len(test.documents))
+			}
+
+			for index, want := range test.documents {
+				got := documents[index]
+				if got.delimiter != want.delimiter || got.stripTabs != want.stripTabs || got.expand != want.expand || got.body != want.body {
+					t.Errorf("here-document %d of %q = %+v, want %+v", index, test.text, got, want)
+				}
+				if operator := command[got.start:got.end]; operator[:2] != "<<" {
+					t.Errorf("here-document %d of %q spans %q, not its operator", index, test.text, operator)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandHereDocument(t *testing.T) {
+	t.Setenv("NAME", "world")
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{body: "hello $NAME\n", want: "hello world\n"},
+		{body: "hello ${NAME}!\n", want: "hello world!\n"},
+		{body: `cost \$5 \\ \n` + "\n", want: `cost $5 \ \n` + "\n"},
+		{body: "joined \\\nline\n", want: "joined line\n"},
+		{body: "'$NAME' \"$NAME\"\n", want: "'world' \"world\"\n"},
+	}
+
+	for _, test := range tests {
+		if got := expandHereDocument(test.body); got != test.want {
+			t.Errorf("expandHereDocument(%q) = %q, want %q", test.body, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// The colors of the parts of the line highlightLine tells apart.
+const (
+	colorCommand     = "\x1b[32m"
+	colorUnknown     = "\x1b[31m"
+	colorString      = "\x1b[33m"
+	colorVariable    = "\x1b[36m"
+	colorOperator    = "\x1b[35m"
+	colorRedirection = "\x1b[34m"
+	colorUnmatched   = "\x1b[1;31m"
+	colorReset       = "\x1b[0m"
+)
+
+// highlightEnabled reports whether the line is colored while it is typed:
+// shopt highlight is on and neither NO_COLOR nor a dumb terminal asks for
+// plain text.
+func highlightEnabled() bool {
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return shellOptions["highlight"] && !noColor && os.Getenv("TERM") != "dumb"
+}
+
+// highlightLine colors line the way the shell will read it. Words are
+// split and quotes handled with the rules of SplitArgs, and the first word
+// of every command is green when it names a builtin or a program in PATH
+// and red otherwise. Quoted text, variables, operators and redirections
+// get their own colors, and a quote that is never closed is shown in bold
+// red up to the end of the line.
+func highlightLine(line []rune) string {
+	colors := make([]string, len(line))
+
+	// the words of the line and whether each one is a command name
+	type word struct {
+		start, end int
+		isCommand  bool
+	}
+	var words []word
+
+	wordStart := -1
+	commandExpected := true
+	targetExpected := false
+
+	endWord := func(end int) {
+		if wordStart == -1 {
+			return
+		}
+		isCommand := commandExpected && !targetExpected
+		words = append(words, word{start: wordStart, end: end, isCommand: isCommand})
+		if isCommand {
+			commandExpected = false
+		}
+		targetExpected = false
+		wordStart = -1
+	}
+
+	var quote rune
+	quoteStart := 0
+
+	for index := 0; index < len(line); index++ {
+		char := line[index]
+
+		if quote != 0 {
+			colors[index] = colorString
+			switch {
+			case char == quote:
+				quote = 0
+			case quote == '"' && char == '\\' && index+1 < len(line):
+				index++
+				colors[index] = colorString
+			case quote == '"' && char == '$':
+				index = highlightVariable(line, index, colors) - 1
+			}
+			continue
+		}
+
+		switch {
+		case char == '\\':
+			if wordStart == -1 {
+				wordStart = index
+			}
+			index++
+
+		case char == '\'' || char == '"':
+			if wordStart == -1 {
+				wordStart = index
+			}
+			quote, quoteStart = char, index
+			colors[index] = colorString
+
+		case char == '$':
+			if wordStart == -1 {
+				wordStart = index
+			}
+			index = highlightVariable(line, index, colors) - 1
+
+		case char == ' ' || char == '\t' || char == '\n':
+			endWord(index)
+
+		case char == '>' || char == '<' || (isASCIIDigit(char) && wordStart == -1 && redirectionAt(line, index+1)):
+			endWord(index)
+			end := index + 1
+			for end < len(line) && (line[end] == '>' || line[end] == '<' || line[end] == '&' || isASCIIDigit(line[end])) {
+				end++
+			}
+			for position := index; position < end; position++ {
+				colors[position] = colorRedirection
+			}
+			// 2>&1 names a descriptor, anything else takes a file name
+			targetExpected = line[end-1] != '&' && !isASCIIDigit(line[end-1])
+			index = end - 1
+
+		case strings.ContainsRune("|;&()", char):
+			endWord(index)
+			colors[index] = colorOperator
+			commandExpected = true
+			targetExpected = false
+
+		case (char == '{' || char == '}') && wordStart == -1 && commandExpected &&
+			(index+1 == len(line) || line[index+1] == ' ' || line[index+1] == ';'):
+			// the braces of a group are not commands, and the command
+			// after { is still in command position
+			colors[index] = colorOperator
+
+		default:
+			if wordStart == -1 {
+				wordStart = index
+			}
+		}
+	}
+
+	if quote != 0 {
+		for position := quoteStart; position < len(line); position++ {
+			colors[position] = colorUnmatched
+		}
+	} else {
+		endWord(len(line))
+	}
+
+	for _, word := range words {
+		if !word.isCommand {
+			continue
+		}
+
+		color := colorUnknown
+		if isKnownCommand(string(line[word.start:word.end])) {
+			color = colorCommand
+		}
+		// quotes are part of the command name, so the whole word gets
+		// its color, but a variable keeps its own
+		for position := word.start; position < word.end; position++ {
+			if colors[position] == "" || colors[position] == colorString {
+				colors[position] = color
+			}
+		}
+	}
+
+	var builder strings.Builder
+	current := ""
+	for index, char := range line {
+		if colors[index] != current {
+			if current != "" {
+				builder.WriteString(colorReset)
+			}
+			builder.WriteString(colors[index])
+			current = colors[index]
+		}
+		builder.WriteRune(char)
+	}
+	if current != "" {
+		builder.WriteString(colorReset)
+	}
+
+	return builder.String()
+}
+
+// highlightVariable colors the $NAME, ${...} or $? reference at index and
+// returns the index right after it. A lone $ is left as it is.
+func highlightVariable(line []rune, index int, colors []string) int {
+	end := index + 1
+
+	switch {
+	case end < len(line) && line[end] == '?':
+		end++
+	case end < len(line) && line[end] == '{':
+		closing := slices.Index(line[end:], '}')
+		if closing == -1 {
+			end = len(line)
+		} else {
+			end += closing + 1
+		}
+	default:
+		for end < len(line) && (line[end] == '_' || isASCIIDigit(line[end]) || unicode.IsLetter(line[end])) {
+			end++
+		}
+	}
+
+	if end == index+1 {
+		return end
+	}
+	for position := index; position < end; position++ {
+		colors[position] = colorVariable
+	}
+	return end
+}
+
+// redirectionAt reports whether a redirection operator starts at index,
+// after the digits of a descriptor such as the 2 of 2>.
+func redirectionAt(line []rune, index int) bool {
+	for index < len(line) && isASCIIDigit(line[index]) {
+		index++
+	}
+	return index < len(line) && (line[index] == '>' || line[index] == '<')
+}
+
+// isKnownCommand reports whether the typed command word names a builtin or
+// a program that can be run.
+func isKnownCommand(raw string) bool {
+	name := strings.Join(filterAndJoinArgs(SplitArgs(raw)), "")
+	if name == "" {
+		return false
+	}
+	if slices.Contains(builtinTools, name) {
+		return true
+	}
+	_, err := lookupCommand(name)
+	return err == nil
+}
+
+func isASCIIDigit(char rune) bool {
+	return char >= '0' && char <= '9'
+}
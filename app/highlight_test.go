@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestHighlightLine(t *testing.T) {
+	// no program is found, so only the builtins are known commands
+	t.Setenv("PATH", t.TempDir())
+
+	paint := func(color, text string) string {
+		return color + text + colorReset
+	}
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "", want: ""},
+		{line: "echo hi", want: paint(colorCommand, "echo") + " hi"},
+		{line: "nosuchcommand hi", want: paint(colorUnknown, "nosuchcommand") + " hi"},
+		{line: "echo a | cd", want: paint(colorCommand, "echo") + " a " + paint(colorOperator, "|") + " " + paint(colorCommand, "cd")},
+		{line: "pwd; type", want: paint(colorCommand, "pwd") + paint(colorOperator, ";") + " " + paint(colorCommand, "type")},
+		{line: `echo "a $X"`, want: paint(colorCommand, "echo") + " " + paint(colorString, `"a `) + paint(colorVariable, "$X") + paint(colorString, `"`)},
+		{line: "echo 'a $X'", want: paint(colorCommand, "echo") + " " + paint(colorString, "'a $X'")},
+		{line: "echo $? ${HOME}x $", want: paint(colorCommand, "echo") + " " + paint(colorVariable, "$?") + " " + paint(colorVariable, "${HOME}") + "x $"},
+		{line: `echo \$HOME`, want: paint(colorCommand, "echo") + ` \$HOME`},
+		{line: "echo x > out 2>&1", want: paint(colorCommand, "echo") + " x " + paint(colorRedirection, ">") + " out " + paint(colorRedirection, "2>&1")},
+		{line: "> out echo", want: paint(colorRedirection, ">") + " out " + paint(colorCommand, "echo")},
+		{line: "echo 'abc", want: paint(colorCommand, "echo") + " " + paint(colorUnmatched, "'abc")},
+		{line: "{ echo a; }", want: paint(colorOperator, "{") + " " + paint(colorCommand, "echo") + " a" + paint(colorOperator, ";") + " " + paint(colorOperator, "}")},
+		{line: `"ech"o hi`, want: paint(colorCommand, `"ech"o`) + " hi"},
+		{line: `'nosuch' x`, want: paint(colorUnknown, `'nosuch'`) + " x"},
+		{line: `echo "ech"o`, want: paint(colorCommand, "echo") + " " + paint(colorString, `"ech"`) + "o"},
+	}
+
+	for _, test := range tests {
+		if got := highlightLine([]rune(test.line)); got != test.want {
+			t.Errorf("highlightLine(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
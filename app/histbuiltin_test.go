@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// typeLines runs lines the way the prompt does: each one is recorded in
+// the history before it runs.
+func typeLines(lines ...string) string {
+	var output strings.Builder
+	for _, line := range lines {
+		shell.lastRecorded = shell.history.record(line)
+		output.WriteString(runCaptured(line))
+	}
+	return output.String()
+}
+
+func historyLines(history *historyCache) []string {
+	var lines []string
+	for _, entry := range history.memory {
+		lines = append(lines, entry.line)
+	}
+	return lines
+}
+
+func TestHistoryBuiltinEditsTheList(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.history = &historyCache{}
+	t.Setenv("HISTCONTROL", "")
+	t.Setenv("HISTIGNORE", "")
+
+	typeLines("echo a", "echo b", "echo c", "history -d 1")
+	if got, want := historyLines(shell.history), []string{"echo a", "echo c", "history -d 1"}; !slices.Equal(got, want) {
+		t.Fatalf("after history -d 1 the history is %q, want %q", got, want)
+	}
+
+	typeLines("history -d 0-1")
+	if got, want := historyLines(shell.history), []string{"history -d 1", "history -d 0-1"}; !slices.Equal(got, want) {
+		t.Fatalf("after history -d 0-1 the history is %q, want %q", got, want)
+	}
+
+	// history -s replaces its own line, history -p is not kept at all
+	typeLines("history -s echo stored")
+	if output := typeLines("history -p !!"); output != "echo stored\n" {
+		t.Errorf("history -p !! printed %q", output)
+	}
+	if got := historyLines(shell.history); got[len(got)-1] != "echo stored" {
+		t.Errorf("after history -s and -p the history ends with %q", got[len(got)-1])
+	}
+
+	typeLines("history -d 99")
+	if shell.exitStatus != 1 {
+		t.Errorf("history -d 99 exited with %d, want 1", shell.exitStatus)
+	}
+
+	typeLines("history -c")
+	if len(shell.history.memory) != 0 {
+		t.Errorf("history -c left %q", historyLines(shell.history))
+	}
+}
+
+func TestHistorySizeLimits(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.history = &historyCache{}
+	shell.workingDirectory = t.TempDir()
+	t.Setenv("HISTCONTROL", "")
+	t.Setenv("HISTIGNORE", "")
+
+	t.Setenv("HISTSIZE", "3")
+	t.Setenv("HISTFILESIZE", "2")
+	typeLines("echo 1", "echo 2", "echo 3", "echo 4")
+
+	if got, want := historyLines(shell.history), []string{"echo 2", "echo 3", "echo 4"}; !slices.Equal(got, want) {
+		t.Errorf("with HISTSIZE=3 the history is %q, want %q", got, want)
+	}
+	// the entries keep their numbers when the oldest ones are dropped
+	if output := runCaptured("history 1"); !strings.HasPrefix(strings.TrimSpace(output), "3  echo 4") {
+		t.Errorf("history 1 printed %q", output)
+	}
+
+	typeLines("history -w saved")
+	saved, _ := os.ReadFile(filepath.Join(shell.workingDirectory, "saved"))
+	if string(saved) != "echo 4\nhistory -w saved\n" {
+		t.Errorf("with HISTFILESIZE=2 history -w wrote %q", saved)
+	}
+
+	// HISTFILESIZE falls back to HISTSIZE
+	os.Unsetenv("HISTFILESIZE")
+	if limit := historyFileLimit(); limit != 3 {
+		t.Errorf("without HISTFILESIZE the file keeps %d entries, want 3", limit)
+	}
+	t.Setenv("HISTSIZE", "-1")
+	if limit := historyFileLimit(); limit != -1 {
+		t.Errorf("with a negative HISTSIZE the file keeps %d entries, want all of them", limit)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// historyWordDelimiters end an event search string such as !ec.
+const historyWordDelimiters = " \t\n;&()|<>:"
+
+// lastHistorySubstitution is reused by :& and by an empty :s//new/.
+var lastHistorySubstitution struct {
+	old, new string
+	isSet    bool
+}
+
+// expandHistory performs csh style history expansion on line. Events are
+// numbered like the output of the history builtin. printOnly is set when
+// the :p modifier asks for the line to be shown instead of run.
+func expandHistory(line string, history *historyCache) (expanded string, printOnly bool, err error) {
+	// ^old^new^ is a shorthand for !!:s^old^new^
+	if strings.HasPrefix(line, "^") {
+		line = "!!:s" + line
+	}
+
+	var builder strings.Builder
+	var activeQuote byte
+	escaped := false
+
+	for index := 0; index < len(line); index++ {
+		char := line[index]
+
+		switch {
+		case escaped:
+			escaped = false
+		case char == '\\' && activeQuote != '\'':
+			escaped = true
+		case (char == '\'' || char == '"') && (activeQuote == 0 || activeQuote == char):
+			if activeQuote == 0 {
+				activeQuote = char
+			} else {
+				activeQuote = 0
+			}
+		case char == '!' && activeQuote != '\'' && startsHistoryExpansion(line, index, activeQuote):
+			text, end, print, err := expandHistoryReference(line, index, history, builder.String())
+			if err != nil {
+				return "", false, err
+			}
+			builder.WriteString(text)
+			printOnly = printOnly || print
+			index = end - 1
+			continue
+		}
+
+		builder.WriteByte(char)
+	}
+
+	return builder.String(), printOnly, nil
+}
+
+// startsHistoryExpansion reports whether the ! at index begins a history
+// reference. Like bash, a ! followed by a blank, = or ( is left alone, as
+// is one that closes a double quoted string.
+func startsHistoryExpansion(line string, index int, activeQuote byte) bool {
+	if index+1 >= len(line) {
+		return false
+	}
+
+	next := line[index+1]
+	if strings.IndexByte(" \t\n=(", next) != -1 {
+		return false
+	}
+	return !(activeQuote == '"' && next == '"')
+}
+
+// expandHistoryReference expands the event, word designator and modifiers
+// starting at the ! at start. It returns the replacement text and the index
+// of the first character after the reference.
+func expandHistoryReference(line string, start int, history *historyCache, current string) (text string, end int, printOnly bool, err error) {
+	entries := make([]string, len(history.memory))
+	for i, entry := range history.memory {
+		entries[i] = entry.line
+	}
+	index := start + 1
+	var event string
+	found := false
+
+	last := func() {
+		if len(entries) > 0 {
+			event, found = entries[len(entries)-1], true
+		}
+	}
+
+	switch char := line[index]; {
+	case char == '!':
+		index++
+		last()
+
+	case char == '#':
+		// the line typed so far
+		index++
+		event, found = current, true
+
+	case isDigit(char) || (char == '-' && index+1 < len(line) && isDigit(line[index+1])):
+		numberEnd := index + 1
+		for numberEnd < len(line) && isDigit(line[numberEnd]) {
+			numberEnd++
+		}
+		n, _ := strconv.Atoi(line[index:numberEnd])
+		index = numberEnd
+		if n < 0 {
+			n += len(entries)
+		} else {
+			n -= history.base
+		}
+		if n >= 0 && n < len(entries) {
+			event, found = entries[n], true
+		}
+
+	case char == '?':
+		// !?string? finds the most recent command containing string
+		searchEnd := strings.IndexByte(line[index+1:], '?')
+		var search string
+		if searchEnd == -1 {
+			search = line[index+1:]
+			index = len(line)
+		} else {
+			search = line[index+1 : index+1+searchEnd]
+			index += searchEnd + 2
+		}
+		for i := len(entries) - 1; i >= 0 && search != ""; i-- {
+			if strings.Contains(entries[i], search) {
+				event, found = entries[i], true
+				break
+			}
+		}
+
+	case strings.IndexByte("$^*:", char) != -1:
+		// !$, !^, !* and !:n refer to the previous command
+		last()
+
+	default:
+		prefixEnd := index
+		for prefixEnd < len(line) && strings.IndexByte(historyWordDelimiters, line[prefixEnd]) == -1 {
+			prefixEnd++
+		}
+		prefix := line[index:prefixEnd]
+		index = prefixEnd
+		for i := len(entries) - 1; i >= 0; i-- {
+			if strings.HasPrefix(entries[i], prefix) {
+				event, found = entries[i], true
+				break
+			}
+		}
+	}
+
+	if !found {
+		return "", 0, false, fmt.Errorf("%s: event not found", line[start:index])
+	}
+
+	text = event
+
+	// the colon before a designator may be left out when it starts with ^ $ * or %
+	hasDesignator := false
+	if index < len(line) {
+		switch {
+		case line[index] == ':' && index+1 < len(line) &&
+			(isDigit(line[index+1]) || strings.IndexByte("^$*-%", line[index+1]) != -1):
+			index++
+			hasDesignator = true
+		case strings.IndexByte("^$*%", line[index]) != -1:
+			hasDesignator = true
+		}
+	}
+
+	if hasDesignator {
+		text, index, err = selectHistoryWords(line, index, splitHistoryWords(event))
+		if err != nil {
+			return "", 0, false, fmt.Errorf("%s: %v", line[start:index], err)
+		}
+	}
+
+	for index+1 < len(line) && line[index] == ':' {
+		var modifierPrint bool
+		text, index, modifierPrint, err = applyHistoryModifier(line, index+1, text)
+		if err != nil {
+			return "", 0, false, fmt.Errorf("%s: %v", line[start:min(index, len(line))], err)
+		}
+		printOnly = printOnly || modifierPrint
+	}
+
+	return text, index, printOnly, nil
+}
+
+// selectHistoryWords parses a word designator such as 0, ^, $, 2-4, -3, 2*
+// or * at index and returns the selected words joined by spaces.
+func selectHistoryWords(line string, index int, words []string) (string, int, error) {
+	errBadWord := fmt.Errorf("bad word specifier")
+	lastWord := len(words) - 1
+
+	readIndex := func() (int, bool) {
+		if index >= len(line) {
+			return 0, false
+		}
+		switch line[index] {
+		case '^':
+			index++
+			return 1, true
+		case '$':
+			index++
+			return lastWord, true
+		case '%':
+			// the word matched by a !?string? search is not tracked, use the last word
+			index++
+			return lastWord, true
+		}
+
+		numberEnd := index
+		for numberEnd < len(line) && isDigit(line[numberEnd]) {
+			numberEnd++
+		}
+		if numberEnd == index {
+			return 0, false
+		}
+		n, _ := strconv.Atoi(line[index:numberEnd])
+		index = numberEnd
+		return n, true
+	}
+
+	var first, last int
+
+	switch {
+	case line[index] == '*':
+		// all words but the first, which may be none at all
+		index++
+		if lastWord < 1 {
+			return "", index, nil
+		}
+		first, last = 1, lastWord
+
+	case line[index] == '-':
+		index++
+		first = 0
+		var ok bool
+		if last, ok = readIndex(); !ok {
+			return "", index, errBadWord
+		}
+
+	default:
+		var ok bool
+		if first, ok = readIndex(); !ok {
+			return "", index, errBadWord
+		}
+		last = first
+
+		if index < len(line) && line[index] == '*' {
+			index++
+			if first > lastWord {
+				return "", index, nil
+			}
+			last = lastWord
+		} else if index < len(line) && line[index] == '-' {
+			index++
+			// x- stops before the last word
+			if last, ok = readIndex(); !ok {
+				last = lastWord - 1
+			}
+		}
+	}
+
+	if first < 0 || last > lastWord || first > last {
+		return "", index, errBadWord
+	}
+
+	return strings.Join(words[first:last+1], " "), index, nil
+}
+
+// applyHistoryModifier applies the modifier starting at index, just after
+// its colon, to text.
+func applyHistoryModifier(line string, index int, text string) (string, int, bool, error) {
+	global := false
+	if line[index] == 'g' || line[index] == 'G' {
+		global = true
+		index++
+		if index >= len(line) || (line[index] != 's' && line[index] != '&') {
+			return "", index, false, fmt.Errorf("unrecognized history modifier")
+		}
+	}
+
+	switch line[index] {
+	case 'h':
+		if slash := strings.LastIndexByte(text, '/'); slash != -1 {
+			text = text[:slash]
+		}
+		return text, index + 1, false, nil
+
+	case 't':
+		if slash := strings.LastIndexByte(text, '/'); slash != -1 {
+			text = text[slash+1:]
+		}
+		return text, index + 1, false, nil
+
+	case 'r':
+		if dot := strings.LastIndexByte(text, '.'); dot > strings.LastIndexByte(text, '/') {
+			text = text[:dot]
+		}
+		return text, index + 1, false, nil
+
+	case 'e':
+		if dot := strings.LastIndexByte(text, '.'); dot > strings.LastIndexByte(text, '/') {
+			text = text[dot:]
+		} else {
+			text = ""
+		}
+		return text, index + 1, false, nil
+
+	case 'p':
+		return text, index + 1, true, nil
+
+	case 's':
+		index++
+		if index >= len(line) {
+			return "", index, false, fmt.Errorf("no previous substitution")
+		}
+
+		delimiter := line[index]
+		var old, replacement string
+		old, index = readSubstitutionPart(line, index+1, delimiter)
+		replacement, index = readSubstitutionPart(line, index, delimiter)
+
+		if old == "" {
+			if !lastHistorySubstitution.isSet {
+				return "", index, false, fmt.Errorf("no previous substitution")
+			}
+			old = lastHistorySubstitution.old
+		}
+
+		// an unescaped & in the replacement stands for the old text
+		replacement = strings.ReplaceAll(replacement, "\\&", "\x00")
+		replacement = strings.ReplaceAll(replacement, "&", old)
+		replacement = strings.ReplaceAll(replacement, "\x00", "&")
+
+		lastHistorySubstitution.old = old
+		lastHistorySubstitution.new = replacement
+		lastHistorySubstitution.isSet = true
+
+		text, err := substituteHistory(text, global)
+		return text, index, false, err
+
+	case '&':
+		if !lastHistorySubstitution.isSet {
+			return "", index + 1, false, fmt.Errorf("no previous substitution")
+		}
+		text, err := substituteHistory(text, global)
+		return text, index + 1, false, err
+	}
+
+	return "", index, false, fmt.Errorf("unrecognized history modifier")
+}
+
+// readSubstitutionPart reads up to the next unescaped delimiter. The closing
+// delimiter may be left out at the end of the line.
+func readSubstitutionPart(line string, index int, delimiter byte) (string, int) {
+	var builder strings.Builder
+
+	for index < len(line) {
+		char := line[index]
+		if char == delimiter {
+			return builder.String(), index + 1
+		}
+		if char == '\\' && index+1 < len(line) && line[index+1] == delimiter {
+			index++
+			char = delimiter
+		}
+		builder.WriteByte(char)
+		index++
+	}
+
+	return builder.String(), index
+}
+
+func substituteHistory(text string, global bool) (string, error) {
+	old, replacement := lastHistorySubstitution.old, lastHistorySubstitution.new
+	if !strings.Contains(text, old) {
+		return "", fmt.Errorf("substitution failed")
+	}
+
+	if global {
+		return strings.ReplaceAll(text, old, replacement), nil
+	}
+	return strings.Replace(text, old, replacement, 1), nil
+}
+
+// splitHistoryWords splits a history entry into the words designators
+// count. Quoted strings stay inside their word and runs of the operator
+// characters |, &, ;, <, >, ( and ) form words of their own.
+func splitHistoryWords(line string) []string {
+	var words []string
+	var current strings.Builder
+	var activeQuote byte
+	escaped := false
+	inOperator := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			words = append(words, current.String())
+			current.Reset()
+		}
+	}
+
+	for index := 0; index < len(line); index++ {
+		char := line[index]
+
+		switch {
+		case escaped:
+			escaped = false
+		case activeQuote != 0:
+			if char == activeQuote {
+				activeQuote = 0
+			}
+		case char == '\\':
+			escaped = true
+		case char == '\'' || char == '"':
+			activeQuote = char
+		case unicode.IsSpace(rune(char)):
+			flush()
+			inOperator = false
+			continue
+		case strings.IndexByte("|&;<>()", char) != -1:
+			if !inOperator {
+				flush()
+			}
+			inOperator = true
+			current.WriteByte(char)
+			continue
+		}
+
+		if inOperator {
+			flush()
+			inOperator = false
+		}
+		current.WriteByte(char)
+	}
+
+	flush()
+	return words
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestExpandHistory(t *testing.T) {
+	history := &historyCache{memory: []historyEntry{
+		{line: "ls -la /tmp"},
+		{line: "cat /etc/hosts.txt"},
+		{line: "echo hello world"},
+	}}
+
+	tests := []struct {
+		line      string
+		want      string
+		printOnly bool
+		fails     bool
+	}{
+		{line: "echo plain", want: "echo plain"},
+		{line: "!!", want: "echo hello world"},
+		{line: "sudo !!", want: "sudo echo hello world"},
+		{line: "!0", want: "ls -la /tmp"},
+		{line: "!-2", want: "cat /etc/hosts.txt"},
+		{line: "!ls", want: "ls -la /tmp"},
+		{line: "!?hosts?", want: "cat /etc/hosts.txt"},
+		{line: "echo !$", want: "echo world"},
+		{line: "echo !^", want: "echo hello"},
+		{line: "echo !*", want: "echo hello world"},
+		{line: "!ls:0", want: "ls"},
+		{line: "echo !ls:1-2", want: "echo -la /tmp"},
+		{line: "echo !cat:$:h", want: "echo /etc"},
+		{line: "echo !cat:$:t", want: "echo hosts.txt"},
+		{line: "echo !cat:$:r", want: "echo /etc/hosts"},
+		{line: "echo !cat:$:e", want: "echo .txt"},
+		{line: "!!:s/hello/bye/", want: "echo bye world"},
+		{line: "!!:gs/o/0/", want: "ech0 hell0 w0rld"},
+		{line: "^hello^hi^", want: "echo hi world"},
+		{line: "!!:p", want: "echo hello world", printOnly: true},
+		{line: "echo '!!'", want: "echo '!!'"},
+		{line: `echo \!!`, want: `echo \!!`},
+		{line: "echo ! a", want: "echo ! a"},
+		{line: "echo !=", want: "echo !="},
+		{line: "!missing", fails: true},
+		{line: "!42", fails: true},
+		{line: "!!:s/absent/x/", fails: true},
+	}
+
+	for _, test := range tests {
+		got, printOnly, err := expandHistory(test.line, history)
+		if test.fails {
+			if err == nil {
+				t.Errorf("expandHistory(%q) = %q, want an error", test.line, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandHistory(%q) failed: %v", test.line, err)
+			continue
+		}
+		if got != test.want || printOnly != test.printOnly {
+			t.Errorf("expandHistory(%q) = %q, %v, want %q, %v", test.line, got, printOnly, test.want, test.printOnly)
+		}
+	}
+}
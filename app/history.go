@@ -0,0 +1,900 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	"golang.org/x/sys/unix"
+)
+
+// historyEntry is one command line of the history together with the time
+// it was entered. Entries read from a file without timestamps have a zero
+// time.
+type historyEntry struct {
+	line string
+	time time.Time
+	// directory is where the line was run, empty for entries read from a
+	// file
+	directory string
+}
+
+type historyCache struct {
+	memory []historyEntry
+	// base is the number of the first entry in memory, it grows when old
+	// entries are dropped to respect HISTSIZE so numbers stay stable
+	base int
+	// savedLines is how many entries of memory are already in the history
+	// file, history -a only appends the ones after them
+	savedLines int
+	// fileOffset is how far into the history file this session has read or
+	// written, history -n reads what comes after it
+	fileOffset int64
+	// pending holds lines other sessions appended that history -a skipped
+	// over, they are merged by the next history -n
+	pending []historyEntry
+	// persistent is set for the interactive session, subshells started
+	// with -c never write the history file
+	persistent bool
+	// session tells the records of this shell apart from other sessions
+	session string
+	// rl mirrors memory, so the arrow keys and the Ctrl-R/Ctrl-S searches of
+	// readline see exactly the entries the history builtin shows
+	rl *readline.Instance
+}
+
+// add records a command line that was run.
+func (history *historyCache) add(line string) {
+	history.memory = append(history.memory, historyEntry{line: line, time: time.Now(), directory: currentDirectory()})
+	history.trim()
+	history.appendReadline(line)
+}
+
+// record adds a line typed at the prompt unless HISTCONTROL or HISTIGNORE
+// ask for it to be left out, and reports whether it was added. Blank lines
+// are never recorded.
+func (history *historyCache) record(line string) bool {
+	cleanedLine := strings.TrimSpace(line)
+	if cleanedLine == "" {
+		return false
+	}
+
+	control := strings.Split(os.Getenv("HISTCONTROL"), ":")
+	ignoreboth := slices.Contains(control, "ignoreboth")
+
+	if (ignoreboth || slices.Contains(control, "ignorespace")) && unicode.IsSpace(rune(line[0])) {
+		return false
+	}
+
+	previous := ""
+	if len(history.memory) > 0 {
+		previous = history.memory[len(history.memory)-1].line
+	}
+
+	if (ignoreboth || slices.Contains(control, "ignoredups")) && cleanedLine == previous {
+		return false
+	}
+
+	for _, pattern := range splitHistoryIgnore(os.Getenv("HISTIGNORE")) {
+		// & stands for the previous history entry
+		if pattern == "&" {
+			pattern = escapeGlob(previous)
+		}
+		if matchGlob(pattern, cleanedLine) {
+			return false
+		}
+	}
+
+	if slices.Contains(control, "erasedups") {
+		for index := len(history.memory) - 1; index >= 0; index-- {
+			if history.memory[index].line == cleanedLine {
+				history.remove(index, index+1)
+			}
+		}
+	}
+
+	history.add(cleanedLine)
+	return true
+}
+
+// dropCurrentLine removes the entry of the line running, if it was
+// recorded, for the builtins that are not kept in the history themselves.
+func (history *historyCache) dropCurrentLine() {
+	if !shell.lastRecorded || len(history.memory) == 0 {
+		return
+	}
+	history.remove(len(history.memory)-1, len(history.memory))
+	shell.lastRecorded = false
+}
+
+// splitHistoryIgnore splits HISTIGNORE on the colons that are not escaped
+// with a backslash.
+func splitHistoryIgnore(value string) []string {
+	var patterns []string
+	var builder strings.Builder
+
+	for index := 0; index < len(value); index++ {
+		switch {
+		case value[index] == '\\' && index+1 < len(value) && value[index+1] == ':':
+			index++
+			builder.WriteByte(':')
+		case value[index] == ':':
+			patterns = append(patterns, builder.String())
+			builder.Reset()
+		default:
+			builder.WriteByte(value[index])
+		}
+	}
+
+	if builder.Len() > 0 {
+		patterns = append(patterns, builder.String())
+	}
+	return patterns
+}
+
+// matchGlob matches the whole of text against a shell pattern with *, ?,
+// [...] classes and backslash escapes. Unlike filepath.Match, * also
+// matches slashes, which is what HISTIGNORE expects.
+func matchGlob(pattern, text string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for index := 0; index <= len(text); index++ {
+				if matchGlob(pattern, text[index:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if text == "" {
+				return false
+			}
+			_, size := utf8.DecodeRuneInString(text)
+			pattern, text = pattern[1:], text[size:]
+
+		case '[':
+			if text == "" {
+				return false
+			}
+			char, size := utf8.DecodeRuneInString(text)
+			matched, rest, ok := matchGlobClass(pattern, char)
+			if !ok {
+				// an unterminated class is an ordinary [
+				if text[0] != '[' {
+					return false
+				}
+				pattern, text = pattern[1:], text[1:]
+				continue
+			}
+			if !matched {
+				return false
+			}
+			pattern, text = rest, text[size:]
+
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if text == "" || text[0] != pattern[0] {
+				return false
+			}
+			pattern, text = pattern[1:], text[1:]
+		}
+	}
+
+	return text == ""
+}
+
+// matchGlobClass matches char against the [...] class at the start of
+// pattern and returns the pattern after the class.
+func matchGlobClass(pattern string, char rune) (matched bool, rest string, ok bool) {
+	index := 1
+	negated := index < len(pattern) && (pattern[index] == '!' || pattern[index] == '^')
+	if negated {
+		index++
+	}
+
+	first := true
+	for index < len(pattern) && (first || pattern[index] != ']') {
+		first = false
+
+		low, size := utf8.DecodeRuneInString(pattern[index:])
+		index += size
+		high := low
+
+		if index+1 < len(pattern) && pattern[index] == '-' && pattern[index+1] != ']' {
+			high, size = utf8.DecodeRuneInString(pattern[index+1:])
+			index += 1 + size
+		}
+
+		if low <= char && char <= high {
+			matched = true
+		}
+	}
+
+	if index >= len(pattern) {
+		return false, "", false
+	}
+	return matched != negated, pattern[index+1:], true
+}
+
+func escapeGlob(text string) string {
+	var builder strings.Builder
+	for index := 0; index < len(text); index++ {
+		if strings.IndexByte("*?[\\", text[index]) != -1 {
+			builder.WriteByte('\\')
+		}
+		builder.WriteByte(text[index])
+	}
+	return builder.String()
+}
+
+// syncReadline replaces readline's own history list with memory. It is
+// needed when entries were removed or merged in, which readline has no way
+// to do in place.
+func (history *historyCache) syncReadline() {
+	if history.rl == nil {
+		return
+	}
+
+	history.limitReadline()
+	history.rl.ResetHistory()
+	for _, entry := range history.memory {
+		history.rl.SaveHistory(entry.line)
+	}
+}
+
+// appendReadline adds the entry add just recorded to the end of readline's
+// list, without going through the whole history again.
+func (history *historyCache) appendReadline(line string) {
+	if history.rl == nil {
+		return
+	}
+
+	history.limitReadline()
+	history.rl.SaveHistory(line)
+}
+
+// limitReadline makes readline drop the oldest entries of its list at
+// HISTSIZE, the same ones trim drops from memory. The list also holds the
+// line being edited, which takes one more place.
+func (history *historyCache) limitReadline() {
+	limit := historyLimit("HISTSIZE", defaultHistorySize)
+	if limit < 0 || limit >= math.MaxInt32 {
+		limit = math.MaxInt32 - 1
+	}
+	history.rl.Config.HistoryLimit = limit + 1
+}
+
+// trim drops the oldest entries beyond HISTSIZE.
+func (history *historyCache) trim() {
+	limit := historyLimit("HISTSIZE", defaultHistorySize)
+	if limit < 0 || len(history.memory) <= limit {
+		return
+	}
+
+	dropped := len(history.memory) - limit
+	history.remove(0, dropped)
+	history.base += dropped
+}
+
+// remove deletes the entries from start up to but not including end.
+func (history *historyCache) remove(start, end int) {
+	if start >= end {
+		return
+	}
+	if history.savedLines > start {
+		history.savedLines -= min(history.savedLines, end) - start
+	}
+	history.memory = slices.Delete(history.memory, start, end)
+}
+
+func (history *historyCache) clear() {
+	history.memory = nil
+	history.base = 0
+	history.savedLines = 0
+	history.syncReadline()
+}
+
+// position converts an entry number as shown by the history builtin, or a
+// negative offset from the end, into an index of memory.
+func (history *historyCache) position(text string) (int, bool) {
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, false
+	}
+
+	if n < 0 {
+		n += len(history.memory)
+	} else {
+		n -= history.base
+	}
+	return n, n >= 0 && n < len(history.memory)
+}
+
+// deleteEntries handles history -d with a single position or a start-end range.
+func (history *historyCache) deleteEntries(spec string) error {
+	start, end := spec, spec
+	// the range dash is searched after the first character, which may be
+	// the sign of a negative start
+	if len(spec) > 1 {
+		if dash := strings.IndexByte(spec[1:], '-'); dash != -1 {
+			start, end = spec[:dash+1], spec[dash+2:]
+		}
+	}
+
+	first, firstOk := history.position(start)
+	last, lastOk := history.position(end)
+	if !firstOk || !lastOk || first > last {
+		return fmt.Errorf("%s: history position out of range", spec)
+	}
+
+	history.remove(first, last+1)
+	history.syncReadline()
+	return nil
+}
+
+const defaultHistorySize = 500
+
+// historyLimit reads a size variable such as HISTSIZE. Unset or invalid
+// values give fallback, negative ones mean there is no limit.
+func historyLimit(name string, fallback int) int {
+	value, isSet := os.LookupEnv(name)
+	if !isSet {
+		return fallback
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	if limit < 0 {
+		return -1
+	}
+	return limit
+}
+
+// historyFileLimit is HISTFILESIZE, which defaults to HISTSIZE like in bash.
+func historyFileLimit() int {
+	return historyLimit("HISTFILESIZE", historyLimit("HISTSIZE", defaultHistorySize))
+}
+
+// lastEntries keeps the last limit entries, limit -1 keeps all of them.
+func lastEntries(entries []historyEntry, limit int) []historyEntry {
+	if limit < 0 || len(entries) <= limit {
+		return entries
+	}
+	return entries[len(entries)-limit:]
+}
+
+func NewHistory() historyCache {
+	history := historyCache{
+		persistent: true,
+		session:    fmt.Sprintf("%x-%x", time.Now().UnixNano(), os.Getpid()),
+	}
+
+	if os.Getenv("HISTFILE") != "" {
+		history.handleFlag("-r", os.Getenv("HISTFILE"))
+	}
+
+	return history
+}
+
+// appendsHistory reports whether the history file is appended to instead
+// of being rewritten when the shell exits.
+func appendsHistory() bool {
+	return shellOptions["histappend"] || shellOptions["histincappend"] || shellOptions["histshare"]
+}
+
+// afterCommand runs once a command line has finished. With histincappend
+// the new entries reach HISTFILE right away, histshare also merges the
+// lines other sessions appended so every terminal sees them live.
+func (history *historyCache) afterCommand() {
+	filePath := os.Getenv("HISTFILE")
+	if !history.persistent || filePath == "" {
+		return
+	}
+
+	if shellOptions["histincappend"] || shellOptions["histshare"] {
+		history.handleFlag("-a", filePath)
+	}
+	if shellOptions["histshare"] {
+		history.handleFlag("-n", filePath)
+	}
+}
+
+// save writes the history file when the shell exits. Subshells started
+// with -c never touch it.
+func (history *historyCache) save() {
+	filePath := os.Getenv("HISTFILE")
+	if !history.persistent || filePath == "" {
+		return
+	}
+
+	if appendsHistory() {
+		history.handleFlag("-a", filePath)
+	} else {
+		history.handleFlag("-w", filePath)
+	}
+}
+
+// lockHistoryFile opens a history file and locks it with flock. The lock
+// goes away when the file is closed.
+func lockHistoryFile(filePath string, flags int, how int) (*os.File, error) {
+	absPath, _ := absolutePath(filePath)
+	file, err := os.OpenFile(absPath, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err = unix.Flock(int(file.Fd()), how)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// readHistoryFile reads the entries stored after offset in a history file
+// and returns them with the size of the file.
+func readHistoryFile(filePath string, offset int64) ([]historyEntry, int64, error) {
+	file, err := lockHistoryFile(filePath, os.O_RDONLY, unix.LOCK_SH)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer file.Close()
+
+	return readHistoryFrom(file, offset)
+}
+
+func readHistoryFrom(file *os.File, offset int64) ([]historyEntry, int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := info.Size()
+	// a smaller file was trimmed by another session, what it kept is
+	// already known to us
+	if offset > size {
+		return nil, size, nil
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, size, err
+	}
+	reader := bufio.NewReader(file)
+
+	// after a rewrite the offset may point into the middle of a line
+	if offset > 0 {
+		previous := make([]byte, 1)
+		if _, err := file.ReadAt(previous, offset-1); err == nil && previous[0] != '\n' {
+			reader.ReadString('\n')
+		}
+	}
+
+	entries, err := parseHistory(reader)
+	return entries, size, err
+}
+
+// parseHistory reads history entries. A comment made of # and an epoch, as
+// bash writes when HISTTIMEFORMAT is set, gives the time of the line after it.
+func parseHistory(reader io.Reader) ([]historyEntry, error) {
+	var entries []historyEntry
+	var timestamp time.Time
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		cmd := scanner.Text()
+
+		// an odd number of backslashes at the end of a line joins it with
+		// the next one, see formatHistory
+		cmd, continued := unescapeHistoryLine(cmd)
+		for continued && scanner.Scan() {
+			var next string
+			next, continued = unescapeHistoryLine(scanner.Text())
+			cmd += "\n" + next
+		}
+
+		if epoch, ok := parseHistoryTimestamp(cmd); ok {
+			timestamp = time.Unix(epoch, 0)
+			continue
+		}
+
+		if strings.TrimSpace(cmd) != "" {
+			entries = append(entries, historyEntry{line: cmd, time: timestamp})
+		}
+		timestamp = time.Time{}
+	}
+
+	return entries, scanner.Err()
+}
+
+func parseHistoryTimestamp(line string) (int64, bool) {
+	if len(line) < 2 || line[0] != '#' || !isDigit(line[1]) {
+		return 0, false
+	}
+
+	epoch, err := strconv.ParseInt(line[1:], 10, 64)
+	return epoch, err == nil
+}
+
+// formatHistory serialises entries for the history file. Like bash, each
+// one is preceded by its timestamp only when HISTTIMEFORMAT is set. Like
+// zsh, every line of a multi-line entry but the last ends with a
+// backslash, so the entry reads back as one. The backslashes an entry
+// itself has at the end of a line are doubled so they are not read as one.
+func formatHistory(entries []historyEntry) string {
+	var builder strings.Builder
+	_, withTimestamps := os.LookupEnv("HISTTIMEFORMAT")
+
+	for _, entry := range entries {
+		if withTimestamps && !entry.time.IsZero() {
+			builder.WriteString(fmt.Sprintf("#%d\n", entry.time.Unix()))
+		}
+		lines := strings.Split(entry.line, "\n")
+		for index, line := range lines {
+			builder.WriteString(escapeHistoryLine(line, index < len(lines)-1) + "\n")
+		}
+	}
+
+	return builder.String()
+}
+
+// escapeHistoryLine doubles the backslashes at the end of line and adds
+// one more when the entry goes on with the next line.
+func escapeHistoryLine(line string, continued bool) string {
+	trimmed := strings.TrimRight(line, "\\")
+	escaped := line + line[len(trimmed):]
+	if continued {
+		escaped += "\\"
+	}
+	return escaped
+}
+
+// unescapeHistoryLine undoes escapeHistoryLine and reports whether the
+// entry goes on with the next line.
+func unescapeHistoryLine(line string) (string, bool) {
+	trimmed := strings.TrimRight(line, "\\")
+	backslashes := len(line) - len(trimmed)
+	return trimmed + strings.Repeat("\\", backslashes/2), backslashes%2 == 1
+}
+
+// rewriteHistoryFile replaces the content of a locked history file with
+// the last HISTFILESIZE entries and returns the new size. The file is
+// rewritten in place rather than renamed so the lock stays meaningful.
+func rewriteHistoryFile(file *os.File, entries []historyEntry) (int64, error) {
+	content := formatHistory(lastEntries(entries, historyFileLimit()))
+
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if _, err := file.WriteString(content); err != nil {
+		return 0, err
+	}
+	return int64(len(content)), nil
+}
+
+// mergeFromFile adds entries that are already stored in the history file.
+// They go before the entries history -a has not written yet, so that those
+// are still the ones appended next.
+func (history *historyCache) mergeFromFile(entries []historyEntry) {
+	saved := min(history.savedLines, len(history.memory))
+	history.memory = slices.Insert(history.memory, saved, entries...)
+	history.savedLines = saved + len(entries)
+}
+
+func (history *historyCache) handleFlag(mode, filePath string) error {
+	switch mode {
+	case "-r":
+		entries, size, err := readHistoryFile(filePath, 0)
+		if err != nil {
+			return err
+		}
+
+		history.mergeFromFile(entries)
+		history.fileOffset = size
+		history.pending = nil
+		history.trim()
+		history.syncReadline()
+
+	case "-n":
+		// only the lines other sessions added since we last looked
+		entries, size, err := readHistoryFile(filePath, history.fileOffset)
+		if err != nil {
+			return err
+		}
+
+		history.mergeFromFile(append(history.pending, entries...))
+		history.fileOffset = size
+		history.pending = nil
+		history.trim()
+		history.syncReadline()
+
+	case "-w":
+		file, err := lockHistoryFile(filePath, os.O_RDWR|os.O_CREATE, unix.LOCK_EX)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		size, err := rewriteHistoryFile(file, history.memory)
+		if err != nil {
+			return err
+		}
+		history.savedLines = len(history.memory)
+		history.fileOffset = size
+		history.pending = nil
+
+	case "-a":
+		// O_APPEND and an exclusive lock let several sessions append at the
+		// same time without losing each other's lines
+		file, err := lockHistoryFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, unix.LOCK_EX)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		// lines other sessions appended since we last looked are kept for
+		// history -n, as our own lines are about to follow them
+		foreign, size, err := readHistoryFrom(file, history.fileOffset)
+		if err != nil {
+			return err
+		}
+		history.pending = append(history.pending, foreign...)
+
+		newCommands := history.memory[min(history.savedLines, len(history.memory)):]
+		written, err := file.WriteString(formatHistory(newCommands))
+		if err != nil {
+			return err
+		}
+		history.savedLines = len(history.memory)
+		history.fileOffset = size + int64(written)
+
+		// trim the file to HISTFILESIZE while we still hold the lock
+		if limit := historyFileLimit(); limit >= 0 {
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			entries, err := parseHistory(file)
+			if err != nil {
+				return err
+			}
+			if len(entries) > limit {
+				if history.fileOffset, err = rewriteHistoryFile(file, entries); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func handleHistory(history *historyCache, args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	fail := func(message string, status int) {
+		outputStream(strings.NewReader("history: "+message+"\n"), redirectionTargets, true)
+		shell.exitStatus = status
+	}
+
+	// --dir, --failed and --since query the record store of all sessions
+	if len(words) > 0 && strings.HasPrefix(words[0], "--") {
+		query, err := parseRecordQuery(words)
+		if err != nil {
+			fail(err.Error(), 2)
+			return
+		}
+		if historyRecordFile() == "" {
+			fail(fmt.Sprintf("%s: HISTRECORDFILE is not set", words[0]), 1)
+			return
+		}
+		records, err := readHistoryRecords()
+		if err != nil {
+			fail(fmt.Sprintf("%s: %s", historyRecordFile(), describePathError(err)), 1)
+			return
+		}
+		outputStream(strings.NewReader(formatRecords(records, query)), redirectionTargets, false)
+		return
+	}
+
+	skipAmount := 0
+
+	if len(words) > 0 {
+		switch words[0] {
+		case "-c":
+			history.clear()
+			return
+
+		case "-d":
+			if len(words) < 2 {
+				fail("-d: option requires an argument", 2)
+				return
+			}
+			if err := history.deleteEntries(words[1]); err != nil {
+				fail(err.Error(), 1)
+			}
+			return
+
+		case "-r", "-w", "-a", "-n":
+			filePath := os.Getenv("HISTFILE")
+			if len(words) > 1 {
+				filePath = words[1]
+			}
+			if filePath == "" {
+				fail(fmt.Sprintf("%s: no history file given and HISTFILE is not set", words[0]), 1)
+				return
+			}
+			if err := history.handleFlag(words[0], filePath); err != nil {
+				fail(fmt.Sprintf("%s: %s", filePath, describePathError(err)), 1)
+			}
+			return
+
+		case "-p":
+			// like bash, the history -p line itself is not kept, so !! refers
+			// to the command before it
+			history.dropCurrentLine()
+			history.syncReadline()
+
+			var result strings.Builder
+			for _, word := range words[1:] {
+				expanded, _, err := expandHistory(word, history)
+				if err != nil {
+					fail(err.Error(), 1)
+					return
+				}
+				result.WriteString(expanded + "\n")
+			}
+			outputStream(strings.NewReader(result.String()), redirectionTargets, false)
+			return
+
+		case "-s":
+			// the arguments replace the history -s line as a single entry
+			history.dropCurrentLine()
+			if len(words) > 1 {
+				history.add(strings.Join(words[1:], " "))
+			} else {
+				history.syncReadline()
+			}
+			return
+		}
+
+		limit, err := strconv.Atoi(words[0])
+		if err != nil {
+			if strings.HasPrefix(words[0], "-") {
+				fail(fmt.Sprintf("%s: invalid option", words[0]), 2)
+			} else {
+				fail(fmt.Sprintf("%s: numeric argument required", words[0]), 1)
+			}
+			return
+		}
+		skipAmount = len(history.memory) - limit
+	}
+
+	timeFormat, withTimes := os.LookupEnv("HISTTIMEFORMAT")
+
+	var result strings.Builder
+	for index, entry := range history.memory {
+		if skipAmount > index {
+			continue
+		}
+
+		// HISTTIMEFORMAT goes right before the command, so it usually ends
+		// with a space. Like bash, entries without a time show ??
+		timestamp := ""
+		if withTimes {
+			timestamp = "??"
+			if !entry.time.IsZero() {
+				timestamp = strftime(timeFormat, entry.time)
+			}
+		}
+
+		str := fmt.Sprintf("    %v  %s%v\n", history.base+index, timestamp, entry.line)
+		result.WriteString(str)
+	}
+
+	outputStream(
+		strings.NewReader(result.String()),
+		redirectionTargets,
+		false,
+	)
+}
+
+// historyNavigator replaces readline's up and down keys with a prefix
+// search: whatever was typed before the first key press limits the entries
+// shown to those starting with it. With nothing typed every entry is shown.
+type historyNavigator struct {
+	history *historyCache
+	// typed is the line as the user left it before navigating
+	typed []rune
+	// index is the entry on screen, len(memory) stands for the typed line
+	index      int
+	navigating bool
+	// plain is set for a key bound to previous-history or next-history,
+	// which do not look at the typed text
+	plain bool
+}
+
+func (n *historyNavigator) OnChange(line []rune, pos int, key rune) (newLine []rune, newPos int, ok bool) {
+	memory := n.history.memory
+
+	switch key {
+	case 0:
+		// readline starts reading a new line
+		n.typed = nil
+		n.navigating = false
+		return nil, 0, false
+	case readline.CharPrev, readline.CharNext:
+	default:
+		n.typed = append([]rune(nil), line...)
+		n.navigating = false
+		return nil, 0, false
+	}
+
+	if !n.navigating {
+		n.navigating = true
+		n.index = len(memory)
+	}
+
+	shown := string(n.typed)
+	if n.index < len(memory) {
+		shown = memory[n.index].line
+	}
+
+	step := -1
+	if key == readline.CharNext {
+		step = 1
+	}
+
+	prefix := string(n.typed)
+	if n.plain {
+		prefix = ""
+	}
+	for index := n.index + step; index >= 0 && index <= len(memory); index += step {
+		if index == len(memory) {
+			n.index = index
+			return n.typed, len(n.typed), true
+		}
+
+		// entries equal to the one on screen are skipped, so repeated
+		// commands only need one key press
+		if line := memory[index].line; strings.HasPrefix(line, prefix) && line != shown {
+			n.index = index
+			return []rune(line), len([]rune(line)), true
+		}
+	}
+
+	// nothing further in that direction, keep what is on screen
+	return []rune(shown), len([]rune(shown)), true
+}
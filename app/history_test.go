@@ -0,0 +1,137 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, text string
+		want          bool
+	}{
+		{"ls", "ls", true},
+		{"ls", "ls -l", false},
+		{"ls *", "ls -l", true},
+		{"*", "", true},
+		{"*", "cd /usr/local", true},
+		{"cd*local", "cd /usr/local", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"?", "é", true},
+		{"??", "a", false},
+		{"[abc]x", "bx", true},
+		{"[abc]x", "dx", false},
+		{"[a-c]", "b", true},
+		{"[!a-c]", "b", false},
+		{"[^a-c]", "d", true},
+		{"[]]", "]", true},
+		{"[a-", "[a-", true},
+		{`\*`, "*", true},
+		{`\*`, "x", false},
+		{`a\?`, "a?", true},
+	}
+
+	for _, test := range tests {
+		if got := matchGlob(test.pattern, test.text); got != test.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", test.pattern, test.text, got, test.want)
+		}
+	}
+}
+
+func TestSplitHistoryIgnore(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"ls:pwd", []string{"ls", "pwd"}},
+		{`echo a\:b:&`, []string{"echo a:b", "&"}},
+	}
+
+	for _, test := range tests {
+		if got := splitHistoryIgnore(test.value); !slices.Equal(got, test.want) {
+			t.Errorf("splitHistoryIgnore(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name       string
+		control    string
+		ignore     string
+		line       string
+		recorded   bool
+		wantMemory []string
+	}{
+		{name: "recorded", line: "pwd", recorded: true, wantMemory: []string{"ls", "echo a", "pwd"}},
+		{name: "blank", line: "   ", wantMemory: []string{"ls", "echo a"}},
+		{name: "ignorespace", control: "ignorespace", line: " pwd", wantMemory: []string{"ls", "echo a"}},
+		{name: "ignoredups", control: "ignoredups", line: "echo a", wantMemory: []string{"ls", "echo a"}},
+		{name: "ignoreboth", control: "ignoreboth", line: " echo b", wantMemory: []string{"ls", "echo a"}},
+		{name: "erasedups", control: "erasedups", line: "ls", recorded: true, wantMemory: []string{"echo a", "ls"}},
+		{name: "HISTIGNORE", ignore: "p*:cd", line: "pwd", wantMemory: []string{"ls", "echo a"}},
+		{name: "HISTIGNORE previous entry", ignore: "&", line: "echo a", wantMemory: []string{"ls", "echo a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("HISTCONTROL", test.control)
+			t.Setenv("HISTIGNORE", test.ignore)
+			history := &historyCache{memory: []historyEntry{{line: "ls"}, {line: "echo a"}}}
+
+			if got := history.record(test.line); got != test.recorded {
+				t.Errorf("record(%q) = %v, want %v", test.line, got, test.recorded)
+			}
+
+			var memory []string
+			for _, entry := range history.memory {
+				memory = append(memory, entry.line)
+			}
+			if !slices.Equal(memory, test.wantMemory) {
+				t.Errorf("after record(%q) the history is %q, want %q", test.line, memory, test.wantMemory)
+			}
+		})
+	}
+}
+
+func TestHistoryFileMultiLineEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		want     []string
+		readOnly bool
+	}{
+		{name: "single lines", file: "ls\npwd\n", want: []string{"ls", "pwd"}},
+		{name: "here-document", file: "cat <<EOF\\\nbody\\\nEOF\npwd\n", want: []string{"cat <<EOF\nbody\nEOF", "pwd"}},
+		{name: "line of an entry ending with a backslash", file: "printf '%s\\\\\\\na'\n", want: []string{"printf '%s\\\na'"}},
+		{name: "entry ending with backslashes", file: "echo a \\\\\\\\\npwd\n", want: []string{"echo a \\\\", "pwd"}},
+		{name: "backslash inside a line", file: "echo a\\ b\n", want: []string{"echo a\\ b"}},
+		{name: "timestamps", file: "#100\necho 'a\\\nb'\n#200\nls\n", want: []string{"echo 'a\nb'", "ls"}},
+		{name: "blank lines", file: "ls\n\n\npwd\n", want: []string{"ls", "pwd"}, readOnly: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("HISTTIMEFORMAT", "")
+			entries, err := parseHistory(strings.NewReader(test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var lines []string
+			for _, entry := range entries {
+				lines = append(lines, entry.line)
+			}
+			if !slices.Equal(lines, test.want) {
+				t.Fatalf("parseHistory(%q) = %q, want %q", test.file, lines, test.want)
+			}
+
+			if got := formatHistory(entries); !test.readOnly && got != test.file {
+				t.Errorf("formatHistory(%q) = %q, want %q", lines, got, test.file)
+			}
+		})
+	}
+}
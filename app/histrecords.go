@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// historyRecord is what the record store keeps about a command line on top
+// of the text the history file has.
+type historyRecord struct {
+	Command    string    `json:"command"`
+	Directory  string    `json:"cwd"`
+	Status     int       `json:"status"`
+	Start      time.Time `json:"start"`
+	DurationMs int64     `json:"duration_ms"`
+	Session    string    `json:"session"`
+}
+
+// historyRecordFile is the JSON lines store shared by all sessions. Like
+// HISTFILE it is only kept when HISTRECORDFILE names it.
+func historyRecordFile() string {
+	return os.Getenv("HISTRECORDFILE")
+}
+
+// storeRecord appends the record of a command line that just finished.
+func (history *historyCache) storeRecord(line string, directory string, start time.Time) {
+	filePath := historyRecordFile()
+	if !history.persistent || filePath == "" {
+		return
+	}
+
+	record := historyRecord{
+		Command:    strings.TrimSpace(line),
+		Directory:  directory,
+		Status:     shell.exitStatus,
+		Start:      start,
+		DurationMs: time.Since(start).Milliseconds(),
+		Session:    history.session,
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	absPath, _ := absolutePath(filePath)
+	if err := os.MkdirAll(filepath.Dir(absPath), 0700); err != nil {
+		return
+	}
+
+	// one write per record under the lock, so sessions never interleave
+	file, err := lockHistoryFile(absPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, unix.LOCK_EX)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	file.Write(append(data, '\n'))
+}
+
+func readHistoryRecords() ([]historyRecord, error) {
+	filePath := historyRecordFile()
+	if filePath == "" {
+		return nil, nil
+	}
+
+	file, err := lockHistoryFile(filePath, os.O_RDONLY, unix.LOCK_SH)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record historyRecord
+		// a damaged line should not hide the rest of the store
+		if json.Unmarshal(scanner.Bytes(), &record) == nil {
+			records = append(records, record)
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+// recordQuery holds the filters of history --dir, --failed and --since.
+type recordQuery struct {
+	directory string
+	failed    bool
+	since     time.Time
+	// limit keeps the last limit matches, 0 keeps them all
+	limit int
+}
+
+// parseRecordQuery reads the query flags. Each flag takes its value either
+// after = or as the next word, --dir without a value means the current
+// directory.
+func parseRecordQuery(words []string) (query recordQuery, err error) {
+	for len(words) > 0 {
+		word := words[0]
+		words = words[1:]
+
+		name, value, hasValue := strings.Cut(word, "=")
+		takeValue := func() bool {
+			if !hasValue && len(words) > 0 && !strings.HasPrefix(words[0], "-") {
+				value, hasValue = words[0], true
+				words = words[1:]
+			}
+			return hasValue
+		}
+
+		switch name {
+		case "--dir":
+			if !takeValue() {
+				value = currentDirectory()
+			}
+			if value, err = absolutePath(value); err != nil {
+				return query, err
+			}
+			query.directory = filepath.Clean(value)
+
+		case "--failed":
+			if hasValue {
+				return query, fmt.Errorf("--failed: does not take a value")
+			}
+			query.failed = true
+
+		case "--since":
+			if !takeValue() {
+				return query, fmt.Errorf("--since: option requires an argument")
+			}
+			if query.since, err = parseSince(value); err != nil {
+				return query, err
+			}
+
+		default:
+			limit, convErr := strconv.Atoi(word)
+			if convErr != nil || limit < 0 {
+				return query, fmt.Errorf("%s: invalid option", word)
+			}
+			query.limit = limit
+		}
+	}
+
+	return query, nil
+}
+
+// parseSince accepts a duration back from now such as 90m, 2h or 3d, an
+// epoch as @1700000000, or a date with an optional time.
+func parseSince(value string) (time.Time, error) {
+	if strings.HasPrefix(value, "@") {
+		epoch, err := strconv.ParseInt(value[1:], 10, 64)
+		if err == nil {
+			return time.Unix(epoch, 0), nil
+		}
+	}
+
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return time.Now().AddDate(0, 0, -days), nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%s: invalid time specification", value)
+}
+
+func (query recordQuery) matches(record historyRecord) bool {
+	if query.directory != "" && filepath.Clean(record.Directory) != query.directory {
+		return false
+	}
+	if query.failed && record.Status == 0 {
+		return false
+	}
+	if !query.since.IsZero() && record.Start.Before(query.since) {
+		return false
+	}
+	return true
+}
+
+// formatRecords lists the matching records, oldest first, with their start
+// time, exit status, duration and directory.
+func formatRecords(records []historyRecord, query recordQuery) string {
+	var matching []historyRecord
+	for _, record := range records {
+		if query.matches(record) {
+			matching = append(matching, record)
+		}
+	}
+	if query.limit > 0 && len(matching) > query.limit {
+		matching = matching[len(matching)-query.limit:]
+	}
+
+	timeFormat, hasFormat := os.LookupEnv("HISTTIMEFORMAT")
+
+	var builder strings.Builder
+	for _, record := range matching {
+		start := record.Start.Local().Format("2006-01-02 15:04:05")
+		if hasFormat {
+			start = strings.TrimSpace(strftime(timeFormat, record.Start.Local()))
+		}
+
+		duration := (time.Duration(record.DurationMs) * time.Millisecond).String()
+		builder.WriteString(fmt.Sprintf(
+			"%s  %3d  %8s  %s  %s\n",
+			start,
+			record.Status,
+			duration,
+			abbreviateHome(record.Directory),
+			record.Command,
+		))
+	}
+
+	return builder.String()
+}
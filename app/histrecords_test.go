@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryRecords(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	root := t.TempDir()
+	store := filepath.Join(root, "records", "history.jsonl")
+	t.Setenv("HISTRECORDFILE", store)
+	t.Setenv("HOME", "/nonexistent")
+	unsetenv(t, "HISTTIMEFORMAT")
+
+	history := &historyCache{persistent: true, session: "test-session"}
+	build, docs := filepath.Join(root, "build"), filepath.Join(root, "docs")
+
+	finish := func(line, directory string, status int, start time.Time) {
+		shell.exitStatus = status
+		history.storeRecord(line, directory, start)
+	}
+	now := time.Now()
+	finish("make all ", build, 0, now.Add(-3*time.Hour))
+	finish("make test", build, 2, now.Add(-30*time.Minute))
+	finish("ls", docs, 0, now.Add(-time.Minute))
+
+	records, err := readHistoryRecords()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("read %d records, %v", len(records), err)
+	}
+	if record := records[1]; record.Command != "make test" || record.Directory != build ||
+		record.Status != 2 || record.Session != "test-session" || record.DurationMs < 30*60*1000 {
+		t.Errorf("the second record is %+v", record)
+	}
+	if records[0].Command != "make all" {
+		t.Errorf("the command was stored as %q, without trimming", records[0].Command)
+	}
+
+	// a damaged line does not hide the others
+	file, _ := os.OpenFile(store, os.O_WRONLY|os.O_APPEND, 0)
+	file.WriteString("{not json\n")
+	file.Close()
+
+	shell.workingDirectory = docs
+	queries := []struct {
+		line     string
+		commands []string
+	}{
+		{line: "history --failed", commands: []string{"make test"}},
+		{line: "history --dir " + build, commands: []string{"make all", "make test"}},
+		{line: "history --dir", commands: []string{"ls"}},
+		{line: "history --since 1h", commands: []string{"make test", "ls"}},
+		{line: "history --dir=" + build + " --since=1h", commands: []string{"make test"}},
+		{line: "history --since 2h 1", commands: []string{"ls"}},
+	}
+
+	for _, query := range queries {
+		output := runCaptured(query.line)
+		lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+		if len(lines) != len(query.commands) {
+			t.Errorf("%q printed %q, want the records of %q", query.line, output, query.commands)
+			continue
+		}
+		for index, line := range lines {
+			if !strings.HasSuffix(line, "  "+query.commands[index]) {
+				t.Errorf("line %d of %q is %q, want the record of %q", index, query.line, line, query.commands[index])
+			}
+		}
+	}
+
+	if output := runCaptured("history --failed"); !strings.Contains(output, "    2  ") || !strings.Contains(output, build) {
+		t.Errorf("history --failed printed %q, without the status and directory", output)
+	}
+}
+
+func TestParseRecordQueryErrors(t *testing.T) {
+	for _, words := range [][]string{
+		{"--since"},
+		{"--since", "yesterday"},
+		{"--failed=yes"},
+		{"--color"},
+		{"-3"},
+	} {
+		if _, err := parseRecordQuery(words); err == nil {
+			t.Errorf("parseRecordQuery(%q) accepted it", words)
+		}
+	}
+
+	since, err := parseSince("@1700000000")
+	if err != nil || since.Unix() != 1700000000 {
+		t.Errorf("parseSince(@1700000000) = %v, %v", since, err)
+	}
+	if since, err := parseSince("2d"); err != nil || time.Since(since) < 47*time.Hour {
+		t.Errorf("parseSince(2d) = %v, %v", since, err)
+	}
+}
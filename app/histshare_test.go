@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestHistoryAppendAndMerge(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	unsetenv(t, "HISTTIMEFORMAT")
+	unsetenv(t, "HISTFILESIZE")
+	unsetenv(t, "HISTSIZE")
+
+	path := filepath.Join(t.TempDir(), "history")
+	first, second := &historyCache{}, &historyCache{}
+
+	run := func(history *historyCache, flag string) {
+		t.Helper()
+		if err := history.handleFlag(flag, path); err != nil {
+			t.Fatalf("history %s: %v", flag, err)
+		}
+	}
+
+	first.add("first 1")
+	run(first, "-a")
+	second.add("second 1")
+	run(second, "-a")
+	first.add("first 2")
+	run(first, "-a")
+
+	// each -a only wrote the lines that were new in its session
+	file, _ := os.ReadFile(path)
+	if string(file) != "first 1\nsecond 1\nfirst 2\n" {
+		t.Fatalf("the sessions appended %q", file)
+	}
+
+	run(first, "-n")
+	if got, want := historyLines(first), []string{"first 1", "first 2", "second 1"}; !slices.Equal(got, want) {
+		t.Errorf("after history -n the first session has %q, want %q", got, want)
+	}
+	// the second session never read the file, so first 1 is new to it too
+	run(second, "-n")
+	if got, want := historyLines(second), []string{"second 1", "first 1", "first 2"}; !slices.Equal(got, want) {
+		t.Errorf("after history -n the second session has %q, want %q", got, want)
+	}
+
+	// nothing new: -n adds nothing and -a writes nothing
+	run(first, "-n")
+	run(first, "-a")
+	if got := historyLines(first); len(got) != 3 {
+		t.Errorf("a second history -n left %q", got)
+	}
+	if again, _ := os.ReadFile(path); string(again) != string(file) {
+		t.Errorf("history -a without new lines changed the file to %q", again)
+	}
+}
+
+func TestHistoryAppendWaitsForTheLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	os.WriteFile(path, nil, 0644)
+
+	holder, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	if err := unix.Flock(int(holder.Fd()), unix.LOCK_EX); err != nil {
+		t.Skip("flock is not available:", err)
+	}
+
+	history := &historyCache{}
+	history.add("waiting")
+	done := make(chan error)
+	go func() { done <- history.handleFlag("-a", path) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("history -a went ahead while the file was locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unix.Flock(int(holder.Fd()), unix.LOCK_UN)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if file, _ := os.ReadFile(path); string(file) != "waiting\n" {
+		t.Errorf("history -a wrote %q once the lock was released", file)
+	}
+}
+
+func TestConcurrentSessionsAppend(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	path := filepath.Join(t.TempDir(), "history")
+
+	const sessions, lines = 6, 40
+	var commands []*exec.Cmd
+	for session := range sessions {
+		var script strings.Builder
+		for line := range lines {
+			fmt.Fprintf(&script, "history -s session %d line %d; ", session, line)
+			// a few appends each, so the sessions interleave
+			if line%10 == 9 {
+				fmt.Fprintf(&script, "history -a %s; ", path)
+			}
+		}
+		cmd := exec.Command(self, "-c", script.String())
+		cmd.Env = append(os.Environ(), "HISTFILESIZE=-1", "HISTSIZE=-1")
+		commands = append(commands, cmd)
+	}
+	for _, cmd := range commands {
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cmd := range commands {
+		if err := cmd.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	entries, err := parseHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no line is lost or torn, and every session's lines keep their order
+	if len(entries) != sessions*lines {
+		t.Fatalf("the file has %d entries, want %d", len(entries), sessions*lines)
+	}
+	next := make([]int, sessions)
+	for _, entry := range entries {
+		var session, line int
+		if _, err := fmt.Sscanf(entry.line, "session %d line %d", &session, &line); err != nil {
+			t.Fatalf("torn entry %q", entry.line)
+		}
+		if line != next[session] {
+			t.Fatalf("session %d wrote line %d after line %d", session, line, next[session]-1)
+		}
+		next[session]++
+	}
+}
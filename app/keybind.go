@@ -0,0 +1,722 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// editingActions are the readline functions a key can be bound to, under
+// their bash names and the zsh names of the same widgets, with the key
+// readline already runs them for. The editing mode switches have no key,
+// keyBindings.filter carries them out itself.
+var editingActions = map[string]rune{
+	"abort":                   readline.CharBell,
+	"accept-line":             readline.CharEnter,
+	"backward-char":           readline.CharBackward,
+	"backward-delete-char":    readline.CharBackspace,
+	"backward-kill-word":      readline.CharCtrlW,
+	"backward-word":           readline.MetaBackward,
+	"beginning-of-line":       readline.CharLineStart,
+	"clear-screen":            readline.CharCtrlL,
+	"complete":                readline.CharTab,
+	"delete-char":             readline.CharDelete,
+	"emacs-editing-mode":      0,
+	"end-of-line":             readline.CharLineEnd,
+	"forward-char":            readline.CharForward,
+	"forward-search-history":  readline.CharFwdSearch,
+	"forward-word":            readline.MetaForward,
+	"history-search-backward": readline.CharPrev,
+	"history-search-forward":  readline.CharNext,
+	"kill-line":               readline.CharKill,
+	"kill-word":               readline.MetaDelete,
+	"next-history":            readline.CharNext,
+	"previous-history":        readline.CharPrev,
+	"reverse-search-history":  readline.CharBckSearch,
+	"transpose-chars":         readline.CharTranspose,
+	"unix-line-discard":       readline.CharCtrlU,
+	"unix-word-rubout":        readline.CharCtrlW,
+	"vi-editing-mode":         0,
+	"yank":                    readline.CharCtrlY,
+	// zsh
+	"down-line-or-history":                readline.CharNext,
+	"expand-or-complete":                  readline.CharTab,
+	"history-incremental-search-backward": readline.CharBckSearch,
+	"history-incremental-search-forward":  readline.CharFwdSearch,
+	"up-line-or-history":                  readline.CharPrev,
+}
+
+// plainHistoryActions step through the history one entry at a time. The
+// keys readline moves through the history with on their own, and
+// history-search-backward and history-search-forward, only go to the
+// entries starting with the text typed before the first of them.
+var plainHistoryActions = []string{"previous-history", "next-history", "up-line-or-history", "down-line-or-history"}
+
+// keyBinding is what a key was bound to: an editing action, or with
+// bind -x a shell command. The shell has no functions, so a command line
+// is what a key runs.
+type keyBinding struct {
+	action  string
+	command string
+}
+
+// keymaps holds the keys bound with bind and bindkey. Emacs mode and the
+// insert mode of vi mode have their own, the keys of vi command mode are
+// read by readline itself and cannot be bound.
+var keymaps = map[string]map[rune]keyBinding{
+	"emacs":     {},
+	"vi-insert": {},
+}
+
+// readlineVariables are the settings bind 'set name value' changes besides
+// editing-mode. The mode strings are shown before the prompt when
+// show-mode-in-prompt is on, by default only in vi mode.
+var readlineVariables = map[string]string{
+	"show-mode-in-prompt": "on",
+	"emacs-mode-string":   "",
+	"vi-ins-mode-string":  "(ins)",
+	"vi-cmd-mode-string":  "(cmd)",
+}
+
+// keyBindings applies the bound keys to what readline reads and follows
+// the vi mode readline is in, to show it before the prompt.
+type keyBindings struct {
+	// viCommand is set while vi mode is in command mode
+	viCommand bool
+	// prompt is the prompt of the line being read, without the mode
+	prompt string
+	// command is the command of the bind -x key that ended the line
+	command string
+	// pos is the cursor position, -1 for the end of the line
+	pos int
+	// navigator walks the history for the keys bound to its actions
+	navigator *historyNavigator
+}
+
+// currentKeymap is the keymap of the editing mode the shell is in.
+func currentKeymap() string {
+	if setOptions["vi"] {
+		return "vi-insert"
+	}
+	return "emacs"
+}
+
+// setEditingMode switches between vi and emacs mode, which are the set
+// options vi and emacs. Like readline, vi mode starts in insert mode.
+func setEditingMode(vi bool) {
+	setOptions["vi"] = vi
+	setOptions["emacs"] = !vi
+	if shell.rl != nil {
+		shell.rl.SetVimMode(vi)
+	}
+	if shell.keys != nil {
+		shell.keys.viCommand = false
+	}
+}
+
+func (keys *keyBindings) filter(r rune) (rune, bool) {
+	if keys.navigator != nil {
+		keys.navigator.plain = false
+	}
+
+	if setOptions["vi"] && keys.viCommand {
+		// readline reads these keys itself, the keys an operator such
+		// as d or r waits for never come through here
+		if r == readline.CharEnter || r == readline.CharInterrupt || strings.ContainsRune("iIaAsSc", r) {
+			keys.showViMode(false)
+		}
+		return r, true
+	}
+
+	if binding, ok := keymaps[currentKeymap()][r]; ok {
+		if keys.navigator != nil {
+			keys.navigator.plain = slices.Contains(plainHistoryActions, binding.action)
+		}
+
+		switch binding.action {
+		case "":
+			// only the main loop runs commands, a line for the read
+			// builtin ignores the key
+			if !shell.editingCommand {
+				return r, true
+			}
+			// the line is handed over to the main loop, which runs the
+			// command once readline has left raw mode
+			keys.command = binding.command
+			return readline.CharEnter, true
+		case "vi-editing-mode", "emacs-editing-mode":
+			setEditingMode(binding.action == "vi-editing-mode")
+			keys.setPrompt(keys.prompt)
+			return r, false
+		}
+		r = editingActions[binding.action]
+	}
+
+	if setOptions["vi"] && r == readline.CharEsc {
+		keys.showViMode(true)
+	}
+	return r, true
+}
+
+func (keys *keyBindings) OnChange(line []rune, pos int, key rune) (newLine []rune, newPos int, ok bool) {
+	switch key {
+	case 0:
+		// the line may start with text, with the cursor after it
+		keys.pos = -1
+	case readline.CharEnter, readline.CharCtrlJ:
+		// readline has already emptied the line
+	default:
+		keys.pos = pos
+	}
+	return nil, 0, false
+}
+
+// setPrompt shows prompt for the line being read, after the string of the
+// editing mode.
+func (keys *keyBindings) setPrompt(prompt string) {
+	keys.prompt = prompt
+	shell.prompt = keys.modeString() + prompt
+	shell.rl.SetPrompt(shell.prompt)
+}
+
+// showViMode follows readline into or out of vi command mode and redraws
+// the prompt, as readline does not redraw the line for the keys that only
+// change the mode.
+func (keys *keyBindings) showViMode(command bool) {
+	keys.viCommand = command
+	if shell.editingCommand {
+		keys.setPrompt(keys.prompt)
+		shell.rl.Refresh()
+	}
+}
+
+func (keys *keyBindings) modeString() string {
+	if readlineVariables["show-mode-in-prompt"] != "on" {
+		return ""
+	}
+
+	name := "emacs-mode-string"
+	switch {
+	case setOptions["vi"] && keys.viCommand:
+		name = "vi-cmd-mode-string"
+	case setOptions["vi"]:
+		name = "vi-ins-mode-string"
+	}
+
+	text, err := parseKeySequence(readlineVariables[name], false)
+	if err != nil {
+		return readlineVariables[name]
+	}
+	return string(text)
+}
+
+// runCommand runs the command of the bind -x key that ended line. Like in
+// bash it can read and change the line through READLINE_LINE and
+// READLINE_POINT, and it returns the line to edit next. The cursor is put
+// back at its end.
+func (keys *keyBindings) runCommand(line string) string {
+	command := keys.command
+	keys.command = ""
+
+	pos := keys.pos
+	if pos < 0 || pos > len([]rune(line)) {
+		pos = len([]rune(line))
+	}
+	os.Setenv("READLINE_LINE", line)
+	os.Setenv("READLINE_POINT", strconv.Itoa(pos))
+
+	status := shell.exitStatus
+	runCommandLine(command)
+	shell.exitStatus = status
+
+	line = os.Getenv("READLINE_LINE")
+	os.Unsetenv("READLINE_LINE")
+	os.Unsetenv("READLINE_POINT")
+	return line
+}
+
+// handleBind changes the key bindings with the syntax of bash:
+// bind '"\C-t": function', bind -x '"\C-t": command' and
+// bind 'set variable value'. -p, -X and -v print what was set in a form
+// bind reads back, so the rc file can keep it between sessions.
+func handleBind(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	fail := func(message string, status int) {
+		outputStream(strings.NewReader("bind: "+message+"\n"), redirectionTargets, true)
+		shell.exitStatus = status
+	}
+
+	keymap := currentKeymap()
+	var result strings.Builder
+
+options:
+	for len(words) > 0 && len(words[0]) > 1 && words[0][0] == '-' {
+		word := words[0]
+		words = words[1:]
+
+		for index, flag := range word[1:] {
+			switch flag {
+			case 'l':
+				names := make([]string, 0, len(editingActions))
+				for name := range editingActions {
+					names = append(names, name)
+				}
+				slices.Sort(names)
+				result.WriteString(strings.Join(names, "\n") + "\n")
+			case 'p', 'P':
+				result.WriteString(formatBindings(keymap, false))
+			case 'X':
+				result.WriteString(formatBindings(keymap, true))
+			case 'v', 'V':
+				result.WriteString(formatReadlineVariables())
+			case 'm', 'r', 'x':
+				// the argument is the rest of the word or the next one
+				value := word[index+2:]
+				if value == "" {
+					if len(words) == 0 {
+						fail(fmt.Sprintf("-%c: option requires an argument", flag), 2)
+						return
+					}
+					value, words = words[0], words[1:]
+				}
+
+				switch flag {
+				case 'm':
+					name, err := keymapName(value)
+					if err != nil {
+						fail(err.Error(), 1)
+						return
+					}
+					keymap = name
+				case 'r':
+					sequence, err := parseKeySequence(strings.Trim(value, `"`), false)
+					if err == nil {
+						var key rune
+						if key, err = keyFromSequence(sequence); err == nil {
+							delete(keymaps[keymap], key)
+						}
+					}
+					if err != nil {
+						fail(fmt.Sprintf("%s: %v", value, err), 1)
+					}
+				case 'x':
+					key, command, err := parseBinding(value)
+					if err != nil {
+						fail(err.Error(), 1)
+						break
+					}
+					if len(command) >= 2 && (command[0] == '"' || command[0] == '\'') && command[len(command)-1] == command[0] {
+						command = command[1 : len(command)-1]
+					}
+					keymaps[keymap][key] = keyBinding{command: command}
+				}
+				continue options
+			default:
+				fail(fmt.Sprintf("-%c: invalid option", flag), 2)
+				return
+			}
+		}
+	}
+
+	for _, word := range words {
+		if setting, ok := strings.CutPrefix(strings.TrimSpace(word), "set "); ok {
+			name, value, _ := strings.Cut(strings.TrimSpace(setting), " ")
+			if err := setReadlineVariable(name, strings.TrimSpace(value)); err != nil {
+				fail(err.Error(), 1)
+			}
+			continue
+		}
+
+		key, action, err := parseBinding(word)
+		if err == nil {
+			err = bindAction(keymap, key, action)
+		}
+		if err != nil {
+			fail(err.Error(), 1)
+		}
+	}
+
+	outputStream(strings.NewReader(result.String()), redirectionTargets, false)
+}
+
+// handleBindkey is the zsh front end of the same bindings:
+// bindkey '^T' widget binds, bindkey -e and -v pick the editing mode and
+// bindkey or bindkey -L lists the bindings as bindkey commands.
+func handleBindkey(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	fail := func(message string, status int) {
+		outputStream(strings.NewReader("bindkey: "+message+"\n"), redirectionTargets, true)
+		shell.exitStatus = status
+	}
+
+	keymap := currentKeymap()
+	remove := false
+
+	for len(words) > 0 && len(words[0]) > 1 && words[0][0] == '-' {
+		word := words[0]
+		words = words[1:]
+
+		switch word {
+		case "-e":
+			setEditingMode(false)
+			keymap = currentKeymap()
+		case "-v":
+			setEditingMode(true)
+			keymap = currentKeymap()
+		case "-l":
+			outputStream(strings.NewReader("emacs\nviins\n"), redirectionTargets, false)
+			return
+		case "-L":
+			// the same listing as without arguments
+		case "-r":
+			remove = true
+		case "-M":
+			if len(words) == 0 {
+				fail("-M: keymap name expected", 1)
+				return
+			}
+			name, err := keymapName(words[0])
+			if err != nil {
+				fail(err.Error(), 1)
+				return
+			}
+			keymap = name
+			words = words[1:]
+		default:
+			fail(fmt.Sprintf("%s: invalid option", word), 1)
+			return
+		}
+	}
+
+	if len(words) == 0 {
+		var result strings.Builder
+		for _, key := range boundKeys(keymap) {
+			if binding := keymaps[keymap][key]; binding.action != "" {
+				result.WriteString(fmt.Sprintf("bindkey %s\"%s\" %s\n", bindkeyKeymapOption(keymap), formatKey(key, true), binding.action))
+			}
+		}
+		outputStream(strings.NewReader(result.String()), redirectionTargets, false)
+		return
+	}
+
+	sequence, err := parseKeySequence(words[0], true)
+	var key rune
+	if err == nil {
+		key, err = keyFromSequence(sequence)
+	}
+	if err != nil {
+		fail(fmt.Sprintf("%s: %v", words[0], err), 1)
+		return
+	}
+
+	switch {
+	case remove:
+		delete(keymaps[keymap], key)
+	case len(words) == 1:
+		action := "undefined-key"
+		if binding, ok := keymaps[keymap][key]; ok && binding.action != "" {
+			action = binding.action
+		}
+		outputStream(strings.NewReader(fmt.Sprintf("\"%s\" %s\n", formatKey(key, true), action)), redirectionTargets, false)
+	default:
+		if err := bindAction(keymap, key, words[1]); err != nil {
+			fail(err.Error(), 1)
+		}
+	}
+}
+
+func bindAction(keymap string, key rune, action string) error {
+	if _, ok := editingActions[action]; !ok {
+		return fmt.Errorf("%s: unknown function name", action)
+	}
+	keymaps[keymap][key] = keyBinding{action: action}
+	return nil
+}
+
+// keymapName returns the keymap a bash or zsh keymap name stands for.
+func keymapName(name string) (string, error) {
+	switch name {
+	case "emacs", "emacs-standard":
+		return "emacs", nil
+	case "vi", "vi-insert", "viins":
+		return "vi-insert", nil
+	case "main":
+		return currentKeymap(), nil
+	case "vi-command", "vi-move", "vicmd":
+		return "", fmt.Errorf("%s: the keys of vi command mode cannot be bound", name)
+	}
+	return "", fmt.Errorf("%s: invalid keymap name", name)
+}
+
+func bindkeyKeymapOption(keymap string) string {
+	if keymap == "vi-insert" {
+		return "-M viins "
+	}
+	return ""
+}
+
+// setReadlineVariable sets a variable of bind 'set name value'.
+func setReadlineVariable(name, value string) error {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	switch name {
+	case "editing-mode":
+		if value != "vi" && value != "emacs" {
+			return fmt.Errorf("%s: invalid editing mode", value)
+		}
+		setEditingMode(value == "vi")
+		return nil
+	case "show-mode-in-prompt":
+		value = strings.ToLower(value)
+		if value == "1" {
+			value = "on"
+		}
+		if value != "on" {
+			value = "off"
+		}
+	}
+
+	if _, ok := readlineVariables[name]; !ok {
+		return fmt.Errorf("%s: unknown variable name", name)
+	}
+	readlineVariables[name] = value
+	return nil
+}
+
+func formatReadlineVariables() string {
+	mode := "emacs"
+	if setOptions["vi"] {
+		mode = "vi"
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("set editing-mode %s\n", mode))
+	for _, name := range []string{"show-mode-in-prompt", "emacs-mode-string", "vi-ins-mode-string", "vi-cmd-mode-string"} {
+		value := readlineVariables[name]
+		if strings.HasSuffix(name, "-string") {
+			value = `"` + value + `"`
+		}
+		builder.WriteString(fmt.Sprintf("set %s %s\n", name, value))
+	}
+	return builder.String()
+}
+
+// formatBindings lists the actions bound in keymap, or with commands the
+// bind -x commands, the way bind -p and bind -X print them.
+func formatBindings(keymap string, commands bool) string {
+	var builder strings.Builder
+	for _, key := range boundKeys(keymap) {
+		binding := keymaps[keymap][key]
+		switch {
+		case commands && binding.action == "":
+			builder.WriteString(fmt.Sprintf("\"%s\": \"%s\"\n", formatKey(key, false), binding.command))
+		case !commands && binding.action != "":
+			builder.WriteString(fmt.Sprintf("\"%s\": %s\n", formatKey(key, false), binding.action))
+		}
+	}
+	return builder.String()
+}
+
+// boundKeys returns the keys bound in keymap in the order they are listed.
+func boundKeys(keymap string) []rune {
+	keys := make([]rune, 0, len(keymaps[keymap]))
+	for key := range keymaps[keymap] {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b rune) int {
+		return strings.Compare(formatKey(a, false), formatKey(b, false))
+	})
+	return keys
+}
+
+// parseBinding splits the "keyseq": value of a bind argument.
+func parseBinding(text string) (rune, string, error) {
+	text = strings.TrimSpace(text)
+
+	var sequence, rest string
+	if strings.HasPrefix(text, `"`) {
+		end := 1
+		for end < len(text) && text[end] != '"' {
+			if text[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(text) {
+			return 0, "", fmt.Errorf("%s: no closing `\"' in key binding", text)
+		}
+		sequence, rest = text[1:end], text[end+1:]
+	} else {
+		colon := strings.IndexByte(text, ':')
+		if colon == -1 {
+			return 0, "", fmt.Errorf("%s: no colon in key binding", text)
+		}
+		sequence, rest = text[:colon], text[colon:]
+	}
+
+	rest = strings.TrimSpace(rest)
+	if !strings.HasPrefix(rest, ":") {
+		return 0, "", fmt.Errorf("%s: no colon in key binding", text)
+	}
+
+	keys, err := parseKeySequence(sequence, false)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %v", sequence, err)
+	}
+	key, err := keyFromSequence(keys)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %v", sequence, err)
+	}
+	return key, strings.TrimSpace(rest[1:]), nil
+}
+
+// parseKeySequence reads the keys written with the escapes of bash, \C-x
+// for Ctrl-X, \M-x and \e for Meta, and the usual backslash escapes. With
+// caret the ^X of zsh is read too.
+func parseKeySequence(text string, caret bool) ([]rune, error) {
+	var keys []rune
+	runes := []rune(text)
+
+	control := func(char rune) rune {
+		if char == '?' {
+			return readline.CharBackspace
+		}
+		return char & 0x1f
+	}
+
+	for index := 0; index < len(runes); index++ {
+		char := runes[index]
+
+		if caret && char == '^' && index+1 < len(runes) {
+			index++
+			keys = append(keys, control(runes[index]))
+			continue
+		}
+		if char != '\\' || index+1 == len(runes) {
+			keys = append(keys, char)
+			continue
+		}
+
+		index++
+		switch escape := runes[index]; escape {
+		case 'C', 'M':
+			if index+2 >= len(runes) || runes[index+1] != '-' {
+				return nil, fmt.Errorf("\\%c must be followed by - and a key", escape)
+			}
+			index += 2
+			if escape == 'C' {
+				keys = append(keys, control(runes[index]))
+			} else {
+				// the key after \M- is read by the next round
+				keys = append(keys, readline.CharEsc)
+				index--
+			}
+		case 'e':
+			keys = append(keys, readline.CharEsc)
+		case 'a':
+			keys = append(keys, '\a')
+		case 'd':
+			keys = append(keys, readline.CharBackspace)
+		case 'n':
+			keys = append(keys, '\n')
+		case 'r':
+			keys = append(keys, '\r')
+		case 't':
+			keys = append(keys, '\t')
+		default:
+			keys = append(keys, escape)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("empty key sequence")
+	}
+	return keys, nil
+}
+
+// metaKeys are the Meta keys readline tells apart, with the key pressed
+// after Escape. Escape followed by any other key reaches the shell as that
+// key alone.
+var metaKeys = map[rune]rune{
+	readline.MetaBackward:  'b',
+	readline.MetaForward:   'f',
+	readline.MetaDelete:    'd',
+	readline.MetaTranspose: readline.CharTranspose,
+	readline.MetaBackspace: readline.CharBackspace,
+}
+
+// keyFromSequence returns the single key readline turns keys into. The
+// arrows and Home and End arrive as the control keys with the same
+// function, so binding the up arrow binds Ctrl-P too.
+func keyFromSequence(keys []rune) (rune, error) {
+	switch {
+	case len(keys) == 1:
+		return keys[0], nil
+	case len(keys) == 2 && keys[0] == readline.CharEsc:
+		for meta, key := range metaKeys {
+			if key == keys[1] {
+				return meta, nil
+			}
+		}
+	case len(keys) == 3 && keys[0] == readline.CharEsc && (keys[1] == '[' || keys[1] == 'O'):
+		switch keys[2] {
+		case 'A':
+			return readline.CharPrev, nil
+		case 'B':
+			return readline.CharNext, nil
+		case 'C':
+			return readline.CharForward, nil
+		case 'D':
+			return readline.CharBackward, nil
+		case 'H':
+			return readline.CharLineStart, nil
+		case 'F':
+			return readline.CharLineEnd, nil
+		}
+	}
+	return 0, fmt.Errorf("only single keys, the arrows, Home, End and Meta-b, Meta-d, Meta-f, Meta-Ctrl-T and Meta-Backspace can be bound")
+}
+
+// formatKey writes key the way bind, or with caret bindkey, takes it.
+func formatKey(key rune, caret bool) string {
+	if base, ok := metaKeys[key]; ok {
+		if caret {
+			return "^[" + formatKey(base, caret)
+		}
+		return `\e` + formatKey(base, caret)
+	}
+
+	switch {
+	case key == readline.CharEsc && caret:
+		return "^["
+	case key == readline.CharEsc:
+		return `\e`
+	case key == readline.CharBackspace && caret:
+		return "^?"
+	case key == readline.CharBackspace:
+		return `\C-?`
+	case key < 32 && caret:
+		return "^" + string(key+'@')
+	case key < 32:
+		return `\C-` + string(key+'`')
+	case key == '"' || key == '\\':
+		return `\` + string(key)
+	}
+	return string(key)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func TestParseKeySequence(t *testing.T) {
+	tests := []struct {
+		text  string
+		caret bool
+		want  []rune
+		fails bool
+	}{
+		{text: "a", want: []rune{'a'}},
+		{text: `\C-x`, want: []rune{0x18}},
+		{text: `\C-X`, want: []rune{0x18}},
+		{text: `\C-?`, want: []rune{readline.CharBackspace}},
+		{text: `\M-f`, want: []rune{readline.CharEsc, 'f'}},
+		{text: `\ef`, want: []rune{readline.CharEsc, 'f'}},
+		{text: `\M-\C-t`, want: []rune{readline.CharEsc, readline.CharTranspose}},
+		{text: `\e[A`, want: []rune{readline.CharEsc, '[', 'A'}},
+		{text: `\t\n\r\a\d`, want: []rune{'\t', '\n', '\r', '\a', readline.CharBackspace}},
+		{text: `\"\\`, want: []rune{'"', '\\'}},
+		{text: `trailing\`, want: []rune("trailing\\")},
+		{text: "^X", want: []rune{'^', 'X'}},
+		{text: "^X", caret: true, want: []rune{0x18}},
+		{text: "^[f", caret: true, want: []rune{readline.CharEsc, 'f'}},
+		{text: "^?", caret: true, want: []rune{readline.CharBackspace}},
+		{text: "", fails: true},
+		{text: `\C`, fails: true},
+		{text: `\Cx`, fails: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseKeySequence(test.text, test.caret)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parseKeySequence(%q, %v) = %q, want an error", test.text, test.caret, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, test.want) {
+			t.Errorf("parseKeySequence(%q, %v) = %q, %v, want %q", test.text, test.caret, got, err, test.want)
+		}
+	}
+}
+
+func TestKeyFromSequence(t *testing.T) {
+	tests := []struct {
+		text  string
+		want  rune
+		fails bool
+	}{
+		{text: `\C-g`, want: readline.CharBell},
+		{text: `\M-b`, want: readline.MetaBackward},
+		{text: `\e\C-?`, want: readline.MetaBackspace},
+		{text: `\e[A`, want: readline.CharPrev},
+		{text: `\eOH`, want: readline.CharLineStart},
+		{text: `\M-x`, fails: true},
+		{text: "ab", fails: true},
+	}
+
+	for _, test := range tests {
+		keys, err := parseKeySequence(test.text, false)
+		if err != nil {
+			t.Fatalf("parseKeySequence(%q) failed: %v", test.text, err)
+		}
+		got, err := keyFromSequence(keys)
+		if test.fails {
+			if err == nil {
+				t.Errorf("keyFromSequence(%q) = %d, want an error", test.text, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("keyFromSequence(%q) = %d, %v, want %d", test.text, got, err, test.want)
+		}
+	}
+}
+
+func TestFormatKeyReadsBack(t *testing.T) {
+	keys := []rune{'a', '"', '\\', readline.CharBell, readline.CharEsc, readline.CharBackspace, readline.MetaForward, readline.MetaTranspose}
+
+	for _, caret := range []bool{false, true} {
+		for _, key := range keys {
+			text := formatKey(key, caret)
+			sequence, err := parseKeySequence(text, caret)
+			if err != nil {
+				t.Errorf("formatKey(%d, %v) = %q, which does not parse: %v", key, caret, text, err)
+				continue
+			}
+			if got, err := keyFromSequence(sequence); err != nil || got != key {
+				t.Errorf("formatKey(%d, %v) = %q, which reads back as %d, %v", key, caret, text, got, err)
+			}
+		}
+	}
+}
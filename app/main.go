@@ -2,8 +2,10 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,142 +14,374 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 
 	"github.com/chzyer/readline"
 )
 
-var redirectionsOperators = []string{">", "1>", ">>", "1>>", "2>", "2>>"}
-var builtinTools = []string{"type", "exit", "echo", "pwd", "history"}
+var redirectionsOperators = []string{">", "1>", ">>", "1>>", "2>", "2>>", "<", "2>&1", ">&2", "1>&2"}
+var builtinTools = []string{"type", "exit", "echo", "pwd", "history", "fc", "printf", "read", "cd", "pushd", "popd", "dirs", "shopt", "set", "complete", "compgen", "hash", "export", "unset", "bind", "bindkey"}
+
+// shellState is the context builtins read and update. It lives apart from
+// the process state so that a subshell can work on its own copy.
+type shellState struct {
+	// workingDirectory is the logical current directory. The process never
+	// changes directory itself, children receive it through cmd.Dir.
+	workingDirectory string
+	// directoryStack holds the directories saved by pushd, most recent
+	// first. The current directory is always entry 0 of dirs.
+	directoryStack []string
+	// exitStatus is the status of the last command, as shown by $?.
+	exitStatus int
+
+	history *historyCache
+	rl      *readline.Instance
+	// prompt is the prompt of the line being read
+	prompt string
+	// editingCommand is set while readline reads a command line rather
+	// than a line for the read builtin
+	editingCommand bool
+	// lastRecorded is set while the line running was added to the
+	// history, HISTCONTROL and HISTIGNORE can leave it out
+	lastRecorded bool
+	// pipelineStage is set while a builtin runs as a stage of a pipeline,
+	// exit then ends the stage rather than the shell
+	pipelineStage bool
+	// terminal is the input readline reads keys from, nil unless the
+	// shell is interactive
+	terminal *terminalInput
+	// keys applies bind and bindkey to the keys readline reads, nil unless
+	// the shell is interactive
+	keys *keyBindings
+}
+
+var shell = &shellState{}
+
+// stdinReader is shared by the main loop and builtins such as read when stdin
+// is not a terminal, so that neither of them reads ahead of the other.
+var stdinReader = bufio.NewReader(os.Stdin)
 
 func main() {
+	initWorkingDirectory()
+
+	// -c runs a single command line and exits, which is how subshells start
+	if len(os.Args) >= 3 && os.Args[1] == "-c" {
+		shell.history = &historyCache{}
+		inheritSubshellState()
+		runCommandLine(os.Args[2])
+		os.Exit(shell.exitStatus)
+	}
+
 	history := NewHistory()
+	shell.history = &history
 
-	completer := NewCommandCompleter()
+	statefulComplter := CustomCompleter{}
 
-	statefulComplter := CustomCompleter{
-		inner: completer,
-	}
+	interactive := readline.IsTerminal(int(os.Stdin.Fd()))
+	editLine := &editLineBinding{}
+	suggester := &autosuggestion{history: &history}
+	navigator := &historyNavigator{history: &history}
+	keys := &keyBindings{navigator: navigator}
 
-	rl, err := readline.NewEx(&readline.Config{
-		Prompt:       "$ ",
+	config := &readline.Config{
 		AutoComplete: &statefulComplter,
-		Listener:     &BellListener{completer: &statefulComplter},
-	})
+		Listener: listenerChain{
+			&completionListener{completer: &statefulComplter},
+			keys,
+			suggester,
+			navigator,
+		},
+		Painter:             &linePainter{completer: &statefulComplter, suggester: suggester},
+		FuncFilterInputRune: inputFilters{keys.filter, statefulComplter.filter, editLine.filter, suggester.filter}.filter,
+		// every entry comes from historyCache, which decides what is kept
+		DisableAutoSaveHistory: true,
+		HistoryLimit:           math.MaxInt32,
+	}
+
+	if interactive {
+		if input, err := newTerminalInput(int(os.Stdin.Fd())); err == nil {
+			config.Stdin = input
+			shell.terminal = input
+		}
+	}
+
+	rl, err := readline.NewEx(config)
 
 	if err != nil {
 		panic(err)
 	}
 	defer rl.Close()
 
+	shell.rl = rl
+	shell.keys = keys
+	history.rl = rl
+	history.syncReadline()
+
+	if interactive {
+		runRCFile()
+	}
+
+	// editedLine is the line a bind -x command left to edit further, and
+	// keyCommandRan is set until it is read again
+	editedLine, keyCommandRan := "", false
+
 	for {
 
-		line, err := rl.Readline()
+		var line string
+		if interactive {
+			// PS1 may show the directory, the time or the last status, so
+			// it is expanded again for every line
+			if !keyCommandRan {
+				runPromptCommand()
+			}
+			prompt := primaryPrompt()
+			fmt.Print(prompt.header)
+			keys.setPrompt(prompt.prompt)
+
+			shell.terminal.resume()
+			shell.editingCommand = true
+			line, err = rl.ReadlineWithDefault(editedLine)
+			shell.editingCommand = false
+			editedLine, keyCommandRan = "", false
+		} else {
+			line, err = stdinReader.ReadString('\n')
+			line = strings.TrimSuffix(line, "\n")
+			if err == io.EOF && line != "" {
+				err = nil
+			}
+		}
 		if err != nil {
 			break
 		}
 
+		if keys.command != "" {
+			// like Ctrl-X Ctrl-E, the key that ran the command was not
+			// the last one readline read
+			shell.terminal.pause()
+			editedLine, keyCommandRan = keys.runCommand(line), true
+			continue
+		}
+
+		printOnly := false
+
+		if editLine.requested {
+			// Ctrl-X Ctrl-E: readline is already waiting for the next key,
+			// which has to go to the editor instead
+			editLine.requested = false
+			shell.terminal.pause()
+
+			edited, err := editInEditor("", line+"\n")
+			var exitErr *exec.ExitError
+			if err != nil && !errors.As(err, &exitErr) {
+				printErr(fmt.Sprintf("%v\n", err))
+			}
+			line = strings.TrimSpace(edited)
+			if err != nil || line == "" {
+				continue
+			}
+			fmt.Printf("%s\n", line)
+		} else {
+			// an open quote, group or here-document, or a trailing
+			// backslash or operator, continues on the next lines
+			line, err = readContinuation(line, interactive)
+			if err == readline.ErrInterrupt {
+				continue
+			}
+			if err != nil {
+				printErr("syntax error: unexpected end of file\n")
+				shell.exitStatus = 2
+				if interactive {
+					continue
+				}
+				break
+			}
+
+			// history references such as !! are replaced before anything
+			// else, and the line that will run is shown when it changed.
+			// Like bash, only an interactive shell expands them
+			if interactive {
+				expandedLine, print, err := expandHistory(line, &history)
+				if err != nil {
+					printErr(fmt.Sprintf("%v\n", err))
+					continue
+				}
+				if expandedLine != line {
+					line = expandedLine
+					fmt.Printf("%s\n", line)
+				}
+				printOnly = print
+			}
+		}
+
 		// add cleaned command to history
 		cleanedLine := strings.TrimSpace(line)
-		history.memory = append(history.memory, cleanedLine)
+		recorded := history.record(line)
+		shell.lastRecorded = recorded
 
 		// goes to the next line
 		fmt.Print("\r")
 
-		args := SplitArgs(line)
+		if printOnly {
+			continue
+		}
 
-		if len(args) == 0 {
+		if cleanedLine == "" {
 			printErr("There must be a command\n")
 			continue
 		}
 
-		// remove the space before the first command
-		if strings.TrimSpace(args[0]) == "" {
-			args = args[1:]
+		start, directory := time.Now(), currentDirectory()
+		runCommandLine(line)
+		// scripts read from stdin leave the record store alone
+		if recorded && interactive {
+			history.storeRecord(line, directory, start)
 		}
+		history.afterCommand()
+	}
 
-		noSpaceArgs := filterEmptyArgs(args)
-
-		command := args[0]
-
-		pipeIndex := slices.Index(args, "|")
-		if pipeIndex != -1 {
-			handlePipe(args)
-			continue
-		}
+	// end of input exits like the exit builtin does, with the status of the
+	// last command or of the syntax error that ended the input
+	history.save()
+	rl.Close()
+	os.Exit(shell.exitStatus)
+}
 
-		switch command {
-		case "cd":
-			handleCD(noSpaceArgs)
-		case "pwd":
-			handlePWD(noSpaceArgs)
-		case "history":
-			handleHistory(&history, noSpaceArgs)
-		case "type":
-			handleType(noSpaceArgs)
-		case "exit":
-			// write to history file at the end
-			if os.Getenv("HISTFILE") != "" {
-				history.handleFlag("-w", os.Getenv("HISTFILE"))
-			}
-			handleExit()
-		case "echo":
-			handleEcho(args)
-		default:
-			handleDefault(args)
-		}
+// executeCommand runs a single simple command, either a builtin or a program.
+func executeCommand(args []string) {
+	// remove the space before the first command
+	if len(args) > 0 && strings.TrimSpace(args[0]) == "" {
+		args = args[1:]
+	}
 
+	if len(args) == 0 {
+		return
 	}
-}
 
-func handlePipe(args []string) {
-	pipeSegments := [][]string{}
-	beginning := 0
+	noSpaceArgs := filterEmptyArgs(args)
 
-	for index, val := range args {
-		if val == "|" {
-			pipeSegments = append(pipeSegments, args[beginning:index])
-			beginning = index + 1
-		}
+	command := args[0]
+
+	traceCommand(filterAndJoinArgs(args))
+
+	// builtins report failures by setting a non-zero status themselves
+	shell.exitStatus = 0
+
+	switch command {
+	case "cd":
+		handleCD(args)
+	case "pushd":
+		handlePushd(args)
+	case "popd":
+		handlePopd(args)
+	case "dirs":
+		handleDirs(args)
+	case "pwd":
+		handlePWD(noSpaceArgs)
+	case "history":
+		handleHistory(shell.history, args)
+	case "fc":
+		handleFc(shell.history, args)
+	case "shopt":
+		handleShopt(args)
+	case "set":
+		handleSet(args)
+	case "complete":
+		handleComplete(args)
+	case "compgen":
+		handleCompgen(args)
+	case "hash":
+		handleHash(args)
+	case "export":
+		handleExport(args)
+	case "unset":
+		handleUnset(args)
+	case "bind":
+		handleBind(args)
+	case "bindkey":
+		handleBindkey(args)
+	case "type":
+		handleType(noSpaceArgs)
+	case "exit":
+		// write to history file at the end
+		shell.history.save()
+		handleExit(noSpaceArgs)
+	case "echo":
+		handleEcho(args)
+	case "printf":
+		handlePrintf(args)
+	case "read":
+		handleRead(shell.rl, args)
+	default:
+		handleDefault(args)
 	}
-	// add the last segment
-	pipeSegments = append(pipeSegments, args[beginning:])
+}
 
+func handlePipe(pipeSegments []string) {
 	var commands []*exec.Cmd
-	var previousPipe *os.File = nil
+	var lastCommand *exec.Cmd
+	var previousPipe io.Reader = nil
 
-	for i, segment := range pipeSegments {
-		cleanParts := filterAndJoinArgs(segment)
+	// substitutions are cleaned up once every stage has finished
+	previousSubstitutions := activeSubstitutions
+	defer func() {
+		finishProcessSubstitutions(activeSubstitutions[len(previousSubstitutions):])
+		activeSubstitutions = previousSubstitutions
+	}()
 
-		if len(cleanParts) == 0 {
-			continue
-		}
+	for i, stage := range pipeSegments {
+		var cmd *exec.Cmd
+		var cmdName string
+		var stageTargets redirectionTargets
+
+		if _, inner, rest, ok := parseGroup(stage); ok {
+			// like bash, a group inside a pipeline always runs in a subshell
+			cmd = subshellCommand(inner)
+			cmdName = "subshell"
+			stageTargets = findRedirectionTargets(filterEmptyArgs(SplitArgs(rest)))
+		} else {
+			var substitutions []*processSubstitution
+			stage, substitutions = startProcessSubstitutions(stage)
+			activeSubstitutions = append(activeSubstitutions, substitutions...)
 
-		cmdName := cleanParts[0]
+			segment := SplitArgs(stage)
+			cleanParts := filterAndJoinArgs(segment)
 
-		if cmdName == "type" {
-			// If there is a pipe from a previous command, close it as we don't use it
-			if previousPipe != nil {
-				previousPipe.Close()
+			if len(cleanParts) == 0 {
+				continue
 			}
 
-			noSpaceArgs := filterEmptyArgs(segment)
-			handleType(noSpaceArgs)
+			cmdName = cleanParts[0]
 
-			// if the type is not the last command, we provide a EOF pipe to the next command
-			if i < len(pipeSegments)-1 {
-				r, w, _ := os.Pipe()
-				w.Close()
-				previousPipe = r
-			} else {
+			if slices.Contains(builtinTools, cmdName) {
+				// If there is a pipe from a previous command, close it as builtins don't read it
+				closePipe(previousPipe)
 				previousPipe = nil
+
+				// if the builtin is not the last command, its output becomes the next command's input
+				if i < len(pipeSegments)-1 {
+					previousPipe = captureOutput(func() { runPipelineBuiltin(segment) })
+				} else {
+					runPipelineBuiltin(segment)
+				}
+
+				continue
 			}
 
-			continue
-		}
+			traceCommand(cleanParts)
 
-		cmdArgs := cleanParts[1:]
-		cmd := exec.Command(cmdName, cmdArgs...)
+			if commandPath, err := lookupCommand(cmdName); err == nil {
+				cmd = exec.Command(commandPath, cleanParts[1:]...)
+				cmd.Args[0] = cmdName
+				commandTable.remember(cmdName, commandPath)
+			} else {
+				// starting it fails and reports the missing command
+				cmd = exec.Command(cmdName, cleanParts[1:]...)
+			}
+			cmd.Dir = currentDirectory()
+			attachSubstitutions(cmd)
+			stageTargets = findRedirectionTargets(filterEmptyArgs(segment))
+		}
 
 		if i == 0 {
 			cmd.Stdin = os.Stdin
@@ -155,6 +389,22 @@ func handlePipe(args []string) {
 			cmd.Stdin = previousPipe
 		}
 
+		// a < redirection, such as the one a here-document becomes, takes
+		// the place of the pipe
+		if stageTargets.inputRedirect != "" {
+			inputFile, err := openInputRedirect(stageTargets.inputRedirect)
+			if err != nil {
+				outputStream(strings.NewReader(fmt.Sprintf("%v\n", err)), stageTargets, true)
+				shell.exitStatus = 1
+				// the stages already started still get their input closed
+				// and are waited for
+				closePipe(previousPipe)
+				break
+			}
+			defer inputFile.Close()
+			cmd.Stdin = inputFile
+		}
+
 		if i < len(pipeSegments)-1 {
 			readSide, writeSide, err := os.Pipe()
 			if err != nil {
@@ -166,9 +416,22 @@ func handlePipe(args []string) {
 				return
 			}
 
-			cmd.Stdout = writeSide
+			opened, err := stageOutput(cmd, stageTargets, writeSide)
+			if err != nil {
+				outputStream(strings.NewReader(fmt.Sprintf("Output error: %v\n", err)), redirectionTargets{}, true)
+				shell.exitStatus = 1
+				readSide.Close()
+				writeSide.Close()
+				closePipe(previousPipe)
+				break
+			}
 
-			if err := cmd.Start(); err != nil {
+			err = cmd.Start()
+			writeSide.Close()
+			for _, file := range opened {
+				file.Close()
+			}
+			if err != nil {
 				outputStream(
 					strings.NewReader(fmt.Sprintf("Error starting %s: %v\n", cmdName, err)),
 					redirectionTargets{},
@@ -177,11 +440,7 @@ func handlePipe(args []string) {
 				return
 			}
 
-			writeSide.Close()
-
-			if previousPipe != nil {
-				previousPipe.Close()
-			}
+			closePipe(previousPipe)
 
 			previousPipe = readSide
 			commands = append(commands, cmd)
@@ -191,36 +450,33 @@ func handlePipe(args []string) {
 		}
 
 		// LAST COMMAND
-		redirectionTargets := findRedirectionTargets(filterEmptyArgs(segment))
-
-		finalStdout, _ := cmd.StdoutPipe()
-		finalStderr, _ := cmd.StderrPipe()
+		finalStdout, finalStderr := outputPipes(cmd, stageTargets)
 
 		if err := cmd.Start(); err != nil {
 			outputStream(
 				strings.NewReader(fmt.Sprintf("Error starting %s: %v\n", cmdName, err)),
-				redirectionTargets,
+				stageTargets,
 				true,
 			)
+			shell.exitStatus = 127
 			return
 		}
 
-		if previousPipe != nil {
-			previousPipe.Close()
-		}
+		closePipe(previousPipe)
 
 		commands = append(commands, cmd)
+		lastCommand = cmd
 
 		// we use go func in case the command produce a lot of stdout and stdeer
 		var wg sync.WaitGroup
 		wg.Add(2)
 		go func() {
 			defer wg.Done()
-			outputStream(finalStdout, redirectionTargets, false)
+			outputStream(finalStdout, stageTargets, false)
 		}()
 		go func() {
 			defer wg.Done()
-			outputStream(finalStderr, redirectionTargets, true)
+			outputStream(finalStderr, stageTargets, true)
 		}()
 
 		wg.Wait()
@@ -228,69 +484,87 @@ func handlePipe(args []string) {
 
 	// Wait for all external commands to finish
 	for _, cmd := range commands {
-		_ = cmd.Wait()
+		err := cmd.Wait()
+		if cmd == lastCommand {
+			shell.exitStatus = exitStatusOf(err)
+		}
+	}
+}
+
+// openInputRedirect opens the file of a < redirection.
+func openInputRedirect(path string) (*os.File, error) {
+	absPath, err := absolutePath(path)
+	if err != nil {
+		return nil, err
 	}
+	return os.Open(absPath)
 }
 
-func handleCD(noSpaceArgs []string) {
+func handleCD(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
 	redirectionTargets := findRedirectionTargets(noSpaceArgs)
 	initializeRedirections(redirectionTargets)
 
-	var path string
+	words := filterAndJoinArgs(args[1:])
+	physical := false
 
-	if len(noSpaceArgs) == 1 {
-		path = "~"
-	} else {
-		path = noSpaceArgs[1]
+	// -L (the default) follows symlinks logically, -P resolves them
+	for len(words) > 0 && (words[0] == "-L" || words[0] == "-P") {
+		physical = words[0] == "-P"
+		words = words[1:]
 	}
 
-	if path == "~" {
-		homePath := os.Getenv("HOME")
+	var path string
+	printNewDirectory := false
 
-		err := os.Chdir(homePath)
-		if err != nil {
+	if len(words) == 0 {
+		path = os.Getenv("HOME")
+		if path == "" {
 			outputStream(
-				strings.NewReader(fmt.Sprintf("Cannot change to home directory: %v", err)),
+				strings.NewReader("cd: HOME not set\n"),
 				redirectionTargets,
 				true,
 			)
+			shell.exitStatus = 1
+			return
 		}
-		return
+	} else {
+		path = words[0]
 	}
 
-	_, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			outputStream(
-				strings.NewReader(fmt.Sprintf("cd: %s: No such file or directory\r\n", path)),
-				redirectionTargets,
-				true,
-			)
-		} else {
+	if path == "-" {
+		path = os.Getenv("OLDPWD")
+		if path == "" {
 			outputStream(
-				strings.NewReader(fmt.Sprintf("Unexpected error when checking path status: %v", err)),
+				strings.NewReader("cd: OLDPWD not set\n"),
 				redirectionTargets,
 				true,
 			)
+			shell.exitStatus = 1
+			return
 		}
-		return
+		printNewDirectory = true
+	} else if found, ok := searchCDPath(path); ok {
+		path = found
+		printNewDirectory = true
 	}
 
-	absPath, err := absolutePath(path)
+	err := changeDirectory(path, physical)
 	if err != nil {
 		outputStream(
-			strings.NewReader(fmt.Sprintf("Error while join file path: %v\r\n", err)),
+			strings.NewReader(fmt.Sprintf("cd: %s: %s\r\n", path, describePathError(err))),
 			redirectionTargets,
 			true,
 		)
+		shell.exitStatus = 1
+		return
 	}
 
-	err = os.Chdir(absPath)
-	if err != nil {
+	if printNewDirectory {
 		outputStream(
-			strings.NewReader(fmt.Sprintf("Cannot change to specified location although it exists: %v\r\n", err)),
+			strings.NewReader(fmt.Sprintln(currentDirectory())),
 			redirectionTargets,
-			true,
+			false,
 		)
 	}
 }
@@ -299,14 +573,42 @@ func handlePWD(noSpaceArgs []string) {
 	redirectTargets := findRedirectionTargets(noSpaceArgs)
 	initializeRedirections(redirectTargets)
 
-	currentDir, err := filepath.Abs("./")
-	if err != nil {
-		outputStream(
-			strings.NewReader(fmt.Sprintf("Cannot find current directory path: %v", err)),
-			redirectTargets,
-			true,
-		)
-		return
+	physical := false
+	for _, flag := range noSpaceArgs[1:] {
+		if slices.Contains(redirectionsOperators, flag) {
+			break
+		}
+
+		switch flag {
+		case "-L":
+			physical = false
+		case "-P":
+			physical = true
+		default:
+			outputStream(
+				strings.NewReader(fmt.Sprintf("pwd: %s: invalid option\n", flag)),
+				redirectTargets,
+				true,
+			)
+			shell.exitStatus = 1
+			return
+		}
+	}
+
+	currentDir := currentDirectory()
+
+	if physical {
+		resolved, err := filepath.EvalSymlinks(currentDir)
+		if err != nil {
+			outputStream(
+				strings.NewReader(fmt.Sprintf("Cannot find current directory path: %v", err)),
+				redirectTargets,
+				true,
+			)
+			shell.exitStatus = 1
+			return
+		}
+		currentDir = resolved
 	}
 
 	outputStream(
@@ -316,6 +618,7 @@ func handlePWD(noSpaceArgs []string) {
 	)
 
 }
+
 func handleType(noSpaceArgs []string) {
 	redirectionTargets := findRedirectionTargets(noSpaceArgs)
 	initializeRedirections(redirectionTargets)
@@ -326,6 +629,7 @@ func handleType(noSpaceArgs []string) {
 			redirectionTargets,
 			true,
 		)
+		shell.exitStatus = 1
 		return
 	}
 
@@ -340,13 +644,14 @@ func handleType(noSpaceArgs []string) {
 		return
 	}
 
-	toolAbsPath, err := exec.LookPath(toolName)
+	toolAbsPath, err := lookupCommand(toolName)
 	if err != nil {
 		outputStream(
 			strings.NewReader(fmt.Sprintf("%s: not found\r\n", toolName)),
 			redirectionTargets,
 			true,
 		)
+		shell.exitStatus = 1
 		return
 	}
 
@@ -358,8 +663,23 @@ func handleType(noSpaceArgs []string) {
 	)
 }
 
-func handleExit() {
-	os.Exit(0)
+func handleExit(noSpaceArgs []string) {
+	status := 0
+
+	if len(noSpaceArgs) > 1 && !slices.Contains(redirectionsOperators, noSpaceArgs[1]) {
+		code, err := strconv.Atoi(noSpaceArgs[1])
+		if err != nil {
+			printErr(fmt.Sprintf("exit: %s: numeric argument required\n", noSpaceArgs[1]))
+			code = 2
+		}
+		status = code & 0xff
+	}
+
+	if shell.pipelineStage {
+		shell.exitStatus = status
+		return
+	}
+	os.Exit(status)
 }
 
 func handleEcho(args []string) {
@@ -367,39 +687,48 @@ func handleEcho(args []string) {
 	redirectionTargets := findRedirectionTargets(noSpaceArgs)
 	initializeRedirections(redirectionTargets)
 
-	var output []string
+	words := filterAndJoinArgs(args[1:])
 
-	if len(args) <= 1 {
-		outputStream(
-			strings.NewReader("Lacking agrument: echo [something to echo]\n"),
-			redirectionTargets,
-			true,
-		)
-		return
-	}
+	noNewline := false
+	interpret := false
 
-	restOfTheCommand := args[2:]
-	breakLoop := false
+	// leading words made only of n, e and E after a dash are options,
+	// anything else (including "-") starts the text to print
+	for len(words) > 0 {
+		flag := words[0]
+		if len(flag) < 2 || flag[0] != '-' || strings.Trim(flag[1:], "neE") != "" {
+			break
+		}
 
-	for _, val := range restOfTheCommand {
-		switch {
-		case slices.Contains(redirectionsOperators, val):
-			breakLoop = true
-		case val == "":
-			continue
-		case strings.TrimSpace(val) == "":
-			output = append(output, " ")
-		default:
-			output = append(output, val)
+		for _, option := range flag[1:] {
+			switch option {
+			case 'n':
+				noNewline = true
+			case 'e':
+				interpret = true
+			case 'E':
+				interpret = false
+			}
 		}
+		words = words[1:]
+	}
 
-		if breakLoop {
-			break
+	output := strings.Join(words, " ")
+
+	if interpret {
+		expanded, stop := interpretEscapes(output, true)
+		output = expanded
+		if stop {
+			noNewline = true
 		}
 	}
 
+	if !noNewline {
+		output += "\n"
+	}
+
 	outputStream(
-		strings.NewReader(fmt.Sprintf("%s\n", strings.Join(output, ""))),
+		strings.NewReader(output),
 		redirectionTargets,
 		false,
 	)
@@ -415,20 +744,43 @@ func handleDefault(args []string) {
 
 	initializeRedirections(redirectionTargets)
 
-	_, err := exec.LookPath(command)
+	commandPath, err := lookupCommand(command)
 	if err != nil {
 		outputStream(
 			strings.NewReader(fmt.Sprintf("%s: not found\n", command)),
 			redirectionTargets,
 			true,
 		)
+		shell.exitStatus = 127
 		return
 	}
 
-	cmd := exec.Command(command, cleanedArgs...)
+	cmd := exec.Command(commandPath, cleanedArgs...)
+	// the program sees the name it was called by, not the path it was found at
+	cmd.Args[0] = command
+	cmd.Dir = currentDirectory()
+	commandTable.remember(command, commandPath)
+	// inside a group or subshell os.Stdin may be a file or a pipe
+	cmd.Stdin = os.Stdin
+	attachSubstitutions(cmd)
+
+	if redirectionTargets.inputRedirect != "" {
+		inputPath, _ := absolutePath(redirectionTargets.inputRedirect)
+		inputFile, err := os.Open(inputPath)
+		if err != nil {
+			outputStream(
+				strings.NewReader(fmt.Sprintf("%v\n", err)),
+				redirectionTargets,
+				true,
+			)
+			shell.exitStatus = 1
+			return
+		}
+		defer inputFile.Close()
+		cmd.Stdin = inputFile
+	}
 
-	outPipe, _ := cmd.StdoutPipe()
-	errPipe, _ := cmd.StderrPipe()
+	outPipe, errPipe := outputPipes(cmd, redirectionTargets)
 
 	defer func() {
 		outPipe.Close()
@@ -452,7 +804,7 @@ func handleDefault(args []string) {
 
 	wg.Wait()
 
-	_ = cmd.Wait()
+	shell.exitStatus = exitStatusOf(cmd.Wait())
 
 }
 
@@ -460,6 +812,13 @@ func outputStream(src io.Reader, targets redirectionTargets, isError bool) {
 	var fallback io.Writer
 	var appendPath, redirectPath string
 
+	// 2>&1 and >&2 send the stream wherever the other one goes
+	if isError && targets.errToOut {
+		isError = false
+	} else if !isError && targets.outToErr {
+		isError = true
+	}
+
 	if isError {
 		fallback = os.Stderr
 		appendPath = targets.errAppend
@@ -495,6 +854,7 @@ func getWriters(redirectPath string, appendPath string, fallback io.Writer) ([]i
 
 	// 1. Check for Truncated Redirection (>)
 	if redirectPath != "" {
+		redirectPath, _ = absolutePath(redirectPath)
 		f, err := os.OpenFile(redirectPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 		if err != nil {
 			return nil, nil, err
@@ -506,6 +866,7 @@ func getWriters(redirectPath string, appendPath string, fallback io.Writer) ([]i
 	// 2. Check for Append Redirection (>>)
 	// Note: Per your logic, we allow both!
 	if appendPath != "" {
+		appendPath, _ = absolutePath(appendPath)
 		f, err := os.OpenFile(appendPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 		if err != nil {
 			// Clean up already opened files if this one fails
@@ -556,30 +917,30 @@ func filterEmptyArgs(input []string) (output []string) {
 	return output
 }
 
+// filterAndJoinArgs joins the tokens of SplitArgs back into words, up to
+// the first redirection operator. A quoted empty string such as "" is a
+// token of its own and still makes a word.
 func filterAndJoinArgs(rawArgs []string) (output []string) {
 	var buffer strings.Builder
+	inWord := false
 
 	for _, val := range rawArgs {
-		isEmtpySpace := strings.TrimSpace(val) == ""
-
 		if slices.Contains(redirectionsOperators, val) {
 			break
 		}
 
-		if !isEmtpySpace {
+		if val == "" || strings.TrimSpace(val) != "" {
 			buffer.WriteString(val)
-		} else {
-			if buffer.Len() > 0 {
-				output = append(output, buffer.String())
-				buffer.Reset()
-			}
+			inWord = true
+		} else if inWord {
+			output = append(output, buffer.String())
+			buffer.Reset()
+			inWord = false
 		}
-
 	}
 
-	if buffer.Len() > 0 {
+	if inWord {
 		output = append(output, buffer.String())
-		buffer.Reset()
 	}
 
 	return output
@@ -603,10 +964,24 @@ type redirectionTargets struct {
 	errRedirect    string
 	outputAppend   string
 	errAppend      string
+	inputRedirect  string
+	// errToOut and outToErr record 2>&1 and >&2
+	errToOut bool
+	outToErr bool
 }
 
 func findRedirectionTargets(noSpaceArgs []string) redirectionTargets {
 	var output redirectionTargets
+
+	for _, val := range noSpaceArgs {
+		switch val {
+		case "2>&1":
+			output.errToOut = true
+		case ">&2", "1>&2":
+			output.outToErr = true
+		}
+	}
+
 	for i := 0; i < len(noSpaceArgs)-1; i++ {
 		val := noSpaceArgs[i]
 		switch val {
@@ -622,6 +997,8 @@ func findRedirectionTargets(noSpaceArgs []string) redirectionTargets {
 			output.errRedirect = noSpaceArgs[i+1]
 		case "2>>":
 			output.errAppend = noSpaceArgs[i+1]
+		case "<":
+			output.inputRedirect = noSpaceArgs[i+1]
 		default:
 			continue
 		}
@@ -636,16 +1013,29 @@ func absolutePath(filePath string) (absPath string, err error) {
 	if filepath.IsAbs(filePath) {
 		absPath = filepath.Join(filePath)
 	} else {
-		currentDirectory, err := filepath.Abs("./")
+		absPath = filepath.Join(currentDirectory(), filePath)
+	}
+
+	return absPath, nil
+
+}
+
+// lookupCommand finds the executable for name. Names containing a slash are
+// resolved against the shell's logical directory rather than the process one,
+// other names through the command index.
+func lookupCommand(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		absPath, err := absolutePath(name)
 		if err != nil {
 			return "", err
 		}
-
-		absPath = filepath.Join(currentDirectory, filePath)
+		return exec.LookPath(absPath)
 	}
 
-	return absPath, nil
-
+	if path, ok := commandTable.find(name); ok {
+		return path, nil
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
 }
 
 func SplitArgs(input string) (output []string) {
@@ -654,11 +1044,16 @@ func SplitArgs(input string) (output []string) {
 	var isSpaceOnly bool
 	var isNextCharLiteral bool
 	var isSkipNextChar bool
+	// index of the first character after an expanded $VAR
+	var skipUntil int
 
 	for index, char := range input {
 
 		switch {
 
+		case index < skipUntil:
+			continue
+
 		case isSkipNextChar:
 			isSkipNextChar = false
 			continue
@@ -701,7 +1096,7 @@ func SplitArgs(input string) (output []string) {
 				buffer.WriteRune(char)
 			// inside double quote we only escape some special characters
 			case '"':
-				specialCharacters := []string{"\"", "\\"}
+				specialCharacters := []string{"\"", "\\", "$"}
 
 				var nextChar string
 				if index+1 <= len(input) {
@@ -734,6 +1129,10 @@ func SplitArgs(input string) (output []string) {
 				output = append(output, buffer.String())
 				buffer.Reset()
 				activeQuote = 0
+			} else if char == '$' && activeQuote == '"' {
+				value, end := expandVariableAt(input, index)
+				buffer.WriteString(value)
+				skipUntil = end
 			} else {
 				buffer.WriteRune(char)
 			}
@@ -760,6 +1159,23 @@ func SplitArgs(input string) (output []string) {
 			}
 
 			isSpaceOnly = false
+
+			if char == '~' && isWordStart(output, buffer.Len()) {
+				value, end := expandTildeAt(input, index)
+				buffer.WriteString(value)
+				skipUntil = end
+				continue
+			}
+
+			// variables are expanded here, while quoting is known, so that
+			// the values printf -v and read store can be used as $NAME
+			if char == '$' {
+				value, end := expandVariableAt(input, index)
+				buffer.WriteString(value)
+				skipUntil = end
+				continue
+			}
+
 			buffer.WriteRune(char)
 
 		case unicode.IsSpace(char):
@@ -783,6 +1199,20 @@ func SplitArgs(input string) (output []string) {
 
 }
 
+// isWordStart reports whether the next character begins a new word, meaning
+// nothing is buffered and the previous token (if any) was a separator.
+func isWordStart(output []string, buffered int) bool {
+	if buffered > 0 {
+		return false
+	}
+	if len(output) == 0 {
+		return true
+	}
+
+	previous := output[len(output)-1]
+	return strings.TrimSpace(previous) == "" || previous == "|"
+}
+
 func writeToFile(path string, content string, isAppend bool) {
 	if path == "" {
 		return
@@ -833,29 +1263,68 @@ func findLCP(matches [][]rune) []rune {
 }
 
 type CustomCompleter struct {
-	inner    *readline.PrefixCompleter
 	tabCount int
+	// pending is a completed line for the listener to put in place, for
+	// completions that replace the word where Do can only append to it
+	pending    []rune
+	pendingPos int
+	// query holds the candidates waiting for an answer to "Display all N
+	// possibilities?"
+	query *completionQuery
+	menu  completionMenu
 }
 
-func (c *CustomCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
+// completionQuery is a list of candidates together with the line they
+// complete.
+type completionQuery struct {
+	candidates []completionCandidate
+	line       []rune
+	pos        int
+	quote      byte
+}
 
-	matches, length := c.inner.Do(line, pos)
+func (c *CustomCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
+	word := wordAtCursor(line, pos)
+	candidates := c.complete(word, line, pos)
+	if len(candidates) == 0 && shellOptions["completefuzzy"] {
+		candidates = c.fuzzyCandidates(word, line, pos)
+	}
 
-	if len(matches) == 0 {
+	if len(candidates) == 0 {
 		c.tabCount = 0
+		fmt.Print("\x07")
 		return nil, 0
 	}
 
-	if len(matches) == 1 {
+	// fuzzy matches replace what was typed instead of adding to it
+	fuzzy := candidates[0].typed != ""
+
+	if len(candidates) == 1 {
 		c.tabCount = 0
-		return [][]rune{matches[0]}, 0
+		if fuzzy {
+			c.setPending(completeLine(line, pos, candidates[0], word.quote, true))
+			return nil, 0
+		}
+		return [][]rune{[]rune(completionText(candidates[0], word.quote, true))}, 0
 	}
 
-	lcp := findLCP(matches)
+	if !fuzzy {
+		var suffixes [][]rune
+		for _, candidate := range candidates {
+			suffixes = append(suffixes, []rune(candidate.suffix))
+		}
 
-	if len(lcp) > 0 {
-		c.tabCount = 0
-		return [][]rune{lcp}, 0
+		lcp := findLCP(suffixes)
+
+		if len(lcp) > 0 {
+			c.tabCount = 0
+			return [][]rune{[]rune(escapeCompletion(string(lcp), word.quote))}, 0
+		}
+
+		// fuzzy matches stay in the order of their score
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].display < candidates[j].display
+		})
 	}
 
 	c.tabCount++
@@ -864,230 +1333,89 @@ func (c *CustomCompleter) Do(line []rune, pos int) (newLine [][]rune, length int
 		fmt.Print("\x07")
 		return nil, 0
 	}
+	c.tabCount = 0
 
-	var suggestions []string
-	prefix := string(line)
+	query := completionQuery{candidates: candidates, line: line, pos: pos, quote: word.quote}
 
-	for _, m := range matches {
-		fullWord := prefix + string(m)
-		suggestions = append(suggestions, fullWord)
+	if len(candidates) > completionQueryItems {
+		// the answer is the next key, which filter receives
+		fmt.Printf("\nDisplay all %d possibilities? (y or n)", len(candidates))
+		c.query = &query
+		return nil, 0
 	}
 
-	sort.Strings(suggestions)
-
-	fmt.Printf("\n%s\n", strings.Join(suggestions, " "))
-	fmt.Printf("$ %s", string(line))
+	if shellOptions["completemenu"] {
+		c.openMenu(query)
+	} else {
+		printListing(candidates)
+	}
 
-	c.tabCount = 0
+	// the line is drawn again below the listing or above the menu
+	c.setPending(line, pos)
 	return nil, 0
 }
 
-type BellListener struct {
-	completer *CustomCompleter
+func (c *CustomCompleter) setPending(line []rune, pos int) {
+	c.pending, c.pendingPos = line, pos
 }
 
-func (b *BellListener) OnChange(line []rune, pos int, key rune) (newLine []rune, newPos int, ok bool) {
-	if key == '\t' {
-		lineSoFar := line[:pos]
-
-		matches, _ := b.completer.Do(lineSoFar, len(lineSoFar))
-
-		if len(matches) == 0 {
-			fmt.Print("\x07")
+// completionText is what candidate adds to the line. A finished
+// completion also gets the candidate's terminator.
+func completionText(candidate completionCandidate, quote byte, finished bool) string {
+	completion := escapeCompletion(candidate.suffix, quote)
+	if finished {
+		// a finished word closes the quote it was typed in
+		if candidate.terminator == " " && quote != 0 {
+			completion += string(quote)
 		}
-	} else {
-		b.completer.tabCount = 0
+		completion += candidate.terminator
 	}
-	return nil, 0, false
+	return completion
 }
 
-func NewCommandCompleter() (completer *readline.PrefixCompleter) {
-	commandSet := make(map[string]struct{})
-
-	for _, tool := range builtinTools {
-		commandSet[tool] = struct{}{}
-	}
-
-	pathEnv := os.Getenv("PATH")
-	paths := filepath.SplitList(pathEnv)
-
-	for _, path := range paths {
-		dir, err := os.ReadDir(path)
-		if err != nil {
-			continue
-		}
-
-		for _, file := range dir {
-			if file.IsDir() {
-				continue
-			}
-
-			_, err := exec.LookPath(file.Name())
-			if err != nil {
-				continue
-			}
-
-			commandSet[file.Name()] = struct{}{}
-
-		}
-	}
-
-	var items []readline.PrefixCompleterInterface
-	for command := range commandSet {
-		items = append(items, readline.PcItem(command))
-	}
-
-	completer = readline.NewPrefixCompleter(items...)
-
-	return completer
-
+// completeLine puts candidate in line at pos, in place of the text it
+// replaces.
+func completeLine(line []rune, pos int, candidate completionCandidate, quote byte, finished bool) ([]rune, int) {
+	completion := completionText(candidate, quote, finished)
+	start := pos - len([]rune(candidate.typed))
+	newLine := slices.Concat(line[:start], []rune(completion), line[pos:])
+	return newLine, start + len([]rune(completion))
 }
 
-type historyCache struct {
-	memory        []string
-	lastSavedLine int
+// completionListener resets the double Tab count once any other key is
+// pressed, and puts in place the lines prepared by the completer.
+type completionListener struct {
+	completer *CustomCompleter
 }
 
-func NewHistory() historyCache {
-	var history historyCache
-
-	if os.Getenv("HISTFILE") != "" {
-		history.handleFlag("-r", os.Getenv("HISTFILE"))
+func (l *completionListener) OnChange(line []rune, pos int, key rune) (newLine []rune, newPos int, ok bool) {
+	if key != '\t' {
+		l.completer.tabCount = 0
 	}
 
-	return history
-}
-
-func (history *historyCache) handleFlag(mode, filePath string) error {
-	switch mode {
-	case "-r":
-		file, err := os.Open(filePath)
-		if err != nil {
-			return err
-		}
-
-		defer file.Close()
-
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			cmd := scanner.Text()
-
-			if strings.TrimSpace(cmd) != "" {
-				history.memory = append(history.memory, cmd)
-			}
-		}
-
-		if err := scanner.Err(); err != nil {
-			return err
-		}
-
-	case "-w":
-		var builder strings.Builder
-
-		for _, val := range history.memory {
-			str := fmt.Sprintf("%s\n", val)
-			builder.WriteString(str)
-		}
-
-		writeToFile(filePath, builder.String(), false)
-
-	case "-a":
-		var fileCommands []string
-		file, err := os.Open(filePath)
-		if err != nil {
-			return err
-		}
-
-		defer file.Close()
-
-		scanner := bufio.NewScanner(file)
-		// step 1. Read all the command from the file
-		for scanner.Scan() {
-			cmd := scanner.Text()
-			if strings.TrimSpace(cmd) != "" {
-				fileCommands = append(fileCommands, cmd)
-			}
-		}
-
-		if err := scanner.Err(); err != nil {
-			return err
-		}
-
-		var builder strings.Builder
-		// step 2. Add all the fileCommands to the builder
-		for _, val := range fileCommands {
-			str := fmt.Sprintf("%s\n", val)
-			builder.WriteString(str)
-		}
-
-		// add selected command to the builders
-		for index, val := range history.memory {
-			// because this is append, we only add from the last saved line
-			if history.lastSavedLine != 0 {
-				if index <= history.lastSavedLine {
-					continue
-				}
-			}
-
-			history.lastSavedLine = index
-			str := fmt.Sprintf("%s\n", val)
-			builder.WriteString(str)
-		}
-
-		// we write to the old file, override all the old content
-		writeToFile(filePath, builder.String(), false)
+	if l.completer.pending != nil {
+		newLine, newPos = l.completer.pending, l.completer.pendingPos
+		l.completer.pending = nil
+		return newLine, newPos, true
 	}
-
-	return nil
+	return nil, 0, false
 }
 
-func handleHistory(history *historyCache, noSpaceArgs []string) {
-	redirectionTargets := findRedirectionTargets(noSpaceArgs)
-
-	var (
-		limit, skipAmount int
-		err               error
-	)
-
-	if len(noSpaceArgs) >= 2 {
-		flags := []string{"-r", "-w", "-a"}
-		// check if the flag is one of the correct flag
-		if slices.Index(flags, noSpaceArgs[1]) != -1 {
-			err = history.handleFlag(noSpaceArgs[1], noSpaceArgs[2])
-			if err != nil {
-				outputStream(
-					strings.NewReader(err.Error()),
-					redirectionTargets,
-					true,
-				)
-			}
-			// here we assume the command can neither be history -flag or history n
-			// it cannot be history -n -flag at the same time
-			return
-		}
-
-		limit, err = strconv.Atoi(noSpaceArgs[1])
-		if err == nil {
-			skipAmount = len(history.memory) - limit
-		}
-
-	}
+// listenerChain lets several listeners watch the line being edited. When
+// one of them changes the line, the next ones see the changed line.
+type listenerChain []readline.Listener
 
-	var result strings.Builder
-	for index, command := range history.memory {
-		if skipAmount != 0 {
-			if skipAmount > index {
-				continue
-			}
+func (chain listenerChain) OnChange(line []rune, pos int, key rune) (newLine []rune, newPos int, ok bool) {
+	for _, listener := range chain {
+		if changedLine, changedPos, changed := listener.OnChange(line, pos, key); changed {
+			line, pos = changedLine, changedPos
+			newLine, newPos, ok = changedLine, changedPos, true
 		}
-
-		str := fmt.Sprintf("    %v  %v\n", index, command)
-		result.WriteString(str)
 	}
+	return newLine, newPos, ok
+}
 
-	outputStream(
-		strings.NewReader(result.String()),
-		redirectionTargets,
-		false,
-	)
+// commandNames lists the builtins and the executables found in PATH.
+func commandNames() []string {
+	return append(slices.Clone(builtinTools), commandTable.names()...)
 }
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain lets the test binary stand in for the shell. Subshells and
+// process substitutions start os.Executable with -c, which is the test
+// binary while the tests run.
+func TestMain(m *testing.M) {
+	if len(os.Args) >= 3 && os.Args[1] == "-c" {
+		main()
+	}
+	os.Exit(m.Run())
+}
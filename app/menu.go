@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/chzyer/readline"
+)
+
+// completionQueryItems is how many candidates are shown without asking
+// first, the default of readline's completion-query-items.
+const completionQueryItems = 100
+
+// completionMenu is the list of candidates drawn below the line after the
+// second Tab when shopt completemenu is on. Tab and the arrow keys move
+// the selection, which replaces the word being completed, Enter accepts
+// it and Ctrl-G puts the word back as it was typed. Any other key accepts
+// the selection and is then handled as usual.
+type completionMenu struct {
+	open       bool
+	candidates []completionCandidate
+	// line and pos are the line as it was when the menu opened
+	line  []rune
+	pos   int
+	quote byte
+	// selected is -1 until a candidate is picked
+	selected int
+	// rows is the number of rows of the layout, Left and Right move by it
+	rows int
+}
+
+// filter handles the keys that answer the "Display all" question and the
+// keys pressed while the menu is open. Keys that change the line are
+// turned into CharBell, which readline ignores, and the listener puts the
+// line prepared for them in place.
+func (c *CustomCompleter) filter(r rune) (rune, bool) {
+	if c.query != nil {
+		query := c.query
+		c.query = nil
+
+		if r != 'y' && r != 'Y' && r != ' ' {
+			fmt.Print("\n")
+		} else if shellOptions["completemenu"] {
+			fmt.Print("\n")
+			c.openMenu(*query)
+		} else {
+			printListing(query.candidates)
+		}
+		// readline redraws the line below the answer
+		return r, false
+	}
+
+	menu := &c.menu
+	if !menu.open {
+		return r, true
+	}
+
+	switch r {
+	case readline.CharTab, readline.CharNext:
+		c.moveSelection(1)
+	case readline.CharPrev:
+		c.moveSelection(-1)
+	case readline.CharForward:
+		c.moveSelection(menu.rows)
+	case readline.CharBackward:
+		c.moveSelection(-menu.rows)
+	case readline.CharEnter, readline.CharCtrlJ:
+		menu.open = false
+		if menu.selected == -1 {
+			return r, true
+		}
+		// readline stops reading after Enter until the line is handled,
+		// and this Enter never gets to it
+		shell.rl.Terminal.KickRead()
+		c.setPending(completeLine(menu.line, menu.pos, menu.candidates[menu.selected], menu.quote, true))
+	case readline.CharBell:
+		menu.open = false
+		c.setPending(menu.line, menu.pos)
+	default:
+		menu.open = false
+		return r, true
+	}
+
+	return readline.CharBell, true
+}
+
+// openMenu shows candidates in the menu, nothing is selected yet.
+func (c *CustomCompleter) openMenu(query completionQuery) {
+	c.menu = completionMenu{
+		open:       true,
+		candidates: query.candidates,
+		line:       query.line,
+		pos:        query.pos,
+		quote:      query.quote,
+		selected:   -1,
+		rows:       1,
+	}
+}
+
+// moveSelection selects the candidate by candidates further, wrapping
+// around at either end, and puts it in place of the word.
+func (c *CustomCompleter) moveSelection(by int) {
+	menu := &c.menu
+	count := len(menu.candidates)
+
+	switch {
+	case menu.selected == -1 && by > 0:
+		menu.selected = 0
+	case menu.selected == -1:
+		menu.selected = count - 1
+	default:
+		menu.selected = ((menu.selected+by)%count + count) % count
+	}
+
+	c.setPending(completeLine(menu.line, menu.pos, menu.candidates[menu.selected], menu.quote, false))
+}
+
+// render lays out the open menu for a terminal of the given size. Only
+// the rows around the selection are drawn when they do not all fit.
+func (menu *completionMenu) render(width, height int) []string {
+	if !menu.open {
+		return nil
+	}
+
+	displayWidth, descriptionWidth := 0, 0
+	for _, candidate := range menu.candidates {
+		displayWidth = max(displayWidth, textWidth(candidate.display))
+		descriptionWidth = max(descriptionWidth, textWidth(sanitize(candidate.description)))
+	}
+
+	cellWidth := displayWidth
+	if descriptionWidth > 0 {
+		// descriptions are cut short rather than pushed off the screen
+		descriptionWidth = min(descriptionWidth, width-displayWidth-4)
+		if descriptionWidth > 0 {
+			cellWidth += 2 + descriptionWidth
+		}
+	}
+
+	cells := make([]string, len(menu.candidates))
+	for index, candidate := range menu.candidates {
+		cell := pad(candidate.display, displayWidth)
+		if descriptionWidth > 0 {
+			description := pad(truncate(sanitize(candidate.description), descriptionWidth), descriptionWidth)
+			cell += "  \x1b[2m" + description + "\x1b[0m"
+		}
+		if index == menu.selected {
+			cell = "\x1b[7m" + cell + "\x1b[0m"
+		}
+		cells[index] = cell
+	}
+
+	rows := layoutColumns(cells, cellWidth, width)
+	menu.rows = len(rows)
+
+	visible := max(height-2, 1)
+	if len(rows) <= visible {
+		return rows
+	}
+
+	// the selection stays in view, with a line telling where it is
+	visible = max(visible-1, 1)
+	first := 0
+	if menu.selected != -1 {
+		first = min(max(menu.selected%len(rows)-visible/2, 0), len(rows)-visible)
+	}
+	shown := rows[first : first+visible]
+	return append(shown, fmt.Sprintf("\x1b[2mrows %d to %d of %d\x1b[0m", first+1, first+visible, len(rows)))
+}
+
+// layoutColumns arranges cells of cellWidth in as many columns as fit in
+// width, sorted down the columns like ls and bash do.
+func layoutColumns(cells []string, cellWidth int, width int) []string {
+	columns := max((width+2)/(cellWidth+2), 1)
+	rowCount := (len(cells) + columns - 1) / columns
+
+	rows := make([]string, rowCount)
+	for row := range rows {
+		var builder strings.Builder
+		for column := 0; column < columns; column++ {
+			index := column*rowCount + row
+			if index >= len(cells) {
+				break
+			}
+			if column > 0 {
+				builder.WriteString("  ")
+			}
+			builder.WriteString(cells[index])
+		}
+		rows[row] = strings.TrimRight(builder.String(), " ")
+	}
+	return rows
+}
+
+// printListing lists candidates below the line after the second Tab. A
+// list that fits on one line is printed on one line, longer ones in
+// columns.
+func printListing(candidates []completionCandidate) {
+	width, _ := terminalSize()
+
+	var displays []string
+	displayWidth := 0
+	for _, candidate := range candidates {
+		displays = append(displays, candidate.display)
+		displayWidth = max(displayWidth, textWidth(candidate.display))
+	}
+
+	listing := strings.Join(displays, "  ")
+	if textWidth(listing) > width {
+		cells := make([]string, len(displays))
+		for index, display := range displays {
+			cells[index] = pad(display, displayWidth)
+		}
+		listing = strings.Join(layoutColumns(cells, displayWidth, width), "\n")
+	}
+
+	fmt.Printf("\n%s\n", listing)
+}
+
+// belowLine draws rows under the line being edited and moves the cursor
+// back to the end of the line, where readline expects it to be.
+func belowLine(line []rune, rows []string) string {
+	width, _ := terminalSize()
+	column := (textWidth(shell.prompt) + readline.Runes{}.WidthAll(line)) % width
+
+	var builder strings.Builder
+	for _, row := range rows {
+		builder.WriteString("\r\n" + row)
+	}
+	builder.WriteString(fmt.Sprintf("\x1b[%dA\r", len(rows)))
+	if column > 0 {
+		builder.WriteString(fmt.Sprintf("\x1b[%dC", column))
+	}
+	return builder.String()
+}
+
+// textWidth is how many columns text takes on the terminal, leaving out
+// color sequences.
+func textWidth(text string) int {
+	return readline.Runes{}.WidthAll(readline.Runes{}.ColorFilter([]rune(text)))
+}
+
+func pad(text string, width int) string {
+	return text + strings.Repeat(" ", max(width-textWidth(text), 0))
+}
+
+func truncate(text string, width int) string {
+	if textWidth(text) <= width {
+		return text
+	}
+
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes))+1 > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// sanitize keeps control characters in descriptions, such as the newlines
+// of a variable, from breaking the layout.
+func sanitize(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func TestHistoryNavigator(t *testing.T) {
+	history := &historyCache{}
+	for _, line := range []string{"git status", "ls -l", "git commit", "git commit", "make"} {
+		history.memory = append(history.memory, historyEntry{line: line})
+	}
+
+	// typed is what the line holds when the key is pressed, want what the
+	// navigator puts there
+	type press struct {
+		key   rune
+		typed string
+		want  string
+	}
+
+	sessions := []struct {
+		name    string
+		plain   bool
+		presses []press
+	}{
+		{
+			name: "empty line walks every entry",
+			presses: []press{
+				{key: readline.CharPrev, want: "make"},
+				{key: readline.CharPrev, typed: "make", want: "git commit"},
+				{key: readline.CharPrev, typed: "git commit", want: "ls -l"},
+				{key: readline.CharNext, typed: "ls -l", want: "git commit"},
+			},
+		},
+		{
+			name: "typed prefix limits the entries",
+			presses: []press{
+				{key: 'g', typed: "git"},
+				{key: readline.CharPrev, typed: "git", want: "git commit"},
+				{key: readline.CharPrev, typed: "git commit", want: "git status"},
+				// the oldest match stays on screen
+				{key: readline.CharPrev, typed: "git status", want: "git status"},
+				{key: readline.CharNext, typed: "git status", want: "git commit"},
+				{key: readline.CharNext, typed: "git commit", want: "git"},
+			},
+		},
+		{
+			name:  "previous-history ignores the typed text",
+			plain: true,
+			presses: []press{
+				{key: 'g', typed: "git"},
+				{key: readline.CharPrev, typed: "git", want: "make"},
+				{key: readline.CharPrev, typed: "make", want: "git commit"},
+			},
+		},
+	}
+
+	for _, session := range sessions {
+		t.Run(session.name, func(t *testing.T) {
+			navigator := &historyNavigator{history: history, plain: session.plain}
+			navigator.OnChange(nil, 0, 0)
+
+			for _, press := range session.presses {
+				line, pos, ok := navigator.OnChange([]rune(press.typed), len(press.typed), press.key)
+				if press.key != readline.CharPrev && press.key != readline.CharNext {
+					if ok {
+						t.Errorf("typing %q changed the line to %q", press.key, string(line))
+					}
+					continue
+				}
+				if !ok || string(line) != press.want || pos != len(line) {
+					t.Fatalf("key %d on %q gave %q at %d, want %q at its end", press.key, press.typed, string(line), pos, press.want)
+				}
+			}
+		})
+	}
+}
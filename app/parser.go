@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var errUnbalancedGroup = errors.New("syntax error: unbalanced group")
+
+// listItem is one pipeline of a command list together with the operator
+// (";", "&&" or "||") that connects it to the previous pipeline.
+type listItem struct {
+	operator string
+	text     string
+}
+
+// rawScanner walks a command line while keeping track of quotes, escapes
+// and group nesting, so callers only see characters that are syntax.
+type rawScanner struct {
+	input       string
+	activeQuote byte
+	escaped     bool
+	parenDepth  int
+	braceDepth  int
+}
+
+// step updates the scanner state for the character at index and reports
+// whether that character is unquoted and outside of any group.
+func (s *rawScanner) step(index int) (topLevel bool) {
+	char := s.input[index]
+
+	switch {
+	case s.escaped:
+		s.escaped = false
+		return false
+	case s.activeQuote != 0:
+		if char == s.activeQuote {
+			s.activeQuote = 0
+		} else if char == '\\' && s.activeQuote == '"' {
+			s.escaped = true
+		}
+		return false
+	case char == '\\':
+		s.escaped = true
+		return false
+	case char == '\'' || char == '"':
+		s.activeQuote = char
+		return false
+	case char == '(':
+		s.parenDepth++
+		return false
+	case char == ')':
+		s.parenDepth--
+		return false
+	case char == '{' && isStandaloneWord(s.input, index):
+		s.braceDepth++
+		return false
+	case char == '}' && isStandaloneWord(s.input, index):
+		s.braceDepth--
+		return false
+	}
+
+	return s.parenDepth == 0 && s.braceDepth == 0
+}
+
+// isStandaloneWord reports whether the single character at index forms a
+// word of its own, which is how { and } are recognised as reserved words.
+func isStandaloneWord(input string, index int) bool {
+	before := index == 0 || strings.ContainsRune(" \t\n;|&(", rune(input[index-1]))
+	after := index == len(input)-1 || strings.ContainsRune(" \t\n;|&)<>", rune(input[index+1]))
+	return before && after
+}
+
+// splitCommandList splits a line on the top level ;, &&, || and newlines.
+// Empty commands are dropped, so "a; b;" yields two items.
+func splitCommandList(line string) ([]listItem, error) {
+	var items []listItem
+	scanner := rawScanner{input: line}
+	start := 0
+	operator := ""
+
+	add := func(end int, nextOperator string) {
+		text := strings.TrimSpace(line[start:end])
+		if text != "" {
+			items = append(items, listItem{operator: operator, text: text})
+		}
+		operator = nextOperator
+	}
+
+	for index := 0; index < len(line); index++ {
+		if !scanner.step(index) {
+			continue
+		}
+
+		char := line[index]
+		hasNext := index+1 < len(line)
+
+		switch {
+		case char == '\n' && continuesAfterNewline(line[start:index], operator):
+			// a command ending with |, && or || goes on after the newline
+		case char == ';' || char == '\n':
+			add(index, ";")
+			start = index + 1
+		case char == '&' && hasNext && line[index+1] == '&':
+			add(index, "&&")
+			index++
+			start = index + 1
+		case char == '|' && hasNext && line[index+1] == '|':
+			add(index, "||")
+			index++
+			start = index + 1
+		}
+	}
+
+	if scanner.parenDepth != 0 || scanner.braceDepth != 0 {
+		return nil, errUnbalancedGroup
+	}
+
+	add(len(line), "")
+	return items, nil
+}
+
+// continuesAfterNewline reports whether the pipeline text, which follows
+// operator, ends with | or is still empty after && or ||.
+func continuesAfterNewline(text string, operator string) bool {
+	text = strings.TrimSpace(text)
+	return strings.HasSuffix(text, "|") || (text == "" && (operator == "&&" || operator == "||"))
+}
+
+// splitPipeline splits a pipeline on its top level | characters.
+func splitPipeline(text string) []string {
+	var stages []string
+	scanner := rawScanner{input: text}
+	start := 0
+
+	for index := 0; index < len(text); index++ {
+		if scanner.step(index) && text[index] == '|' {
+			stages = append(stages, strings.TrimSpace(text[start:index]))
+			start = index + 1
+		}
+	}
+
+	return append(stages, strings.TrimSpace(text[start:]))
+}
+
+// parseGroup recognises "( list ) redirections" and "{ list; } redirections".
+// It returns the opening character, the list inside the group and whatever
+// follows the closing character.
+func parseGroup(stage string) (kind byte, inner string, rest string, ok bool) {
+	stage = strings.TrimLeftFunc(stage, unicode.IsSpace)
+	if stage == "" {
+		return 0, "", "", false
+	}
+
+	kind = stage[0]
+	if kind != '(' && !(kind == '{' && isStandaloneWord(stage, 0)) {
+		return 0, "", "", false
+	}
+
+	scanner := rawScanner{input: stage}
+	for index := 0; index < len(stage); index++ {
+		scanner.step(index)
+
+		if scanner.parenDepth == 0 && scanner.braceDepth == 0 && scanner.activeQuote == 0 {
+			return kind, stage[1:index], stage[index+1:], true
+		}
+	}
+
+	return 0, "", "", false
+}
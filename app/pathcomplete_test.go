@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestPathCompletion(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	root := t.TempDir()
+	shell.workingDirectory = root
+	t.Setenv("HOME", root)
+	for _, directory := range []string{"src/app", "src/assets", "my dir", ".config"} {
+		os.MkdirAll(filepath.Join(root, directory), 0755)
+	}
+	for _, file := range []string{"src/main.go", "src/app/run.sh", "notes.txt", "my dir/it's.txt"} {
+		os.WriteFile(filepath.Join(root, file), nil, 0644)
+	}
+	os.Chmod(filepath.Join(root, "src/app/run.sh"), 0755)
+	os.Symlink(filepath.Join(root, "src"), filepath.Join(root, "link"))
+
+	// each candidate is shown as its display text followed by the
+	// terminator a unique match would get
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{line: "cat src/a", want: []string{"app//", "assets//"}},
+		{line: "cat src/m", want: []string{"main.go "}},
+		{line: "cat n", want: []string{"notes.txt "}},
+		{line: "cat ", want: []string{"link//", "my dir//", "notes.txt ", "src//"}},
+		{line: "cat .c", want: []string{".config//"}},
+		{line: "cat my\\ d", want: []string{"my dir//"}},
+		{line: "cat 'my dir/i", want: []string{"it's.txt "}},
+		{line: "cat ~/no", want: []string{"notes.txt "}},
+		{line: "cat $HOME/src/ma", want: []string{"main.go "}},
+		{line: "cat link/a", want: []string{"app//", "assets//"}},
+		// in command position only directories and programs are offered
+		{line: "src/app/", want: []string{"run.sh "}},
+		{line: "./s", want: []string{"src//"}},
+		{line: "cat nothing", want: nil},
+	}
+
+	completer := &CustomCompleter{}
+	for _, test := range tests {
+		line := []rune(test.line)
+		var got []string
+		for _, candidate := range completer.complete(wordAtCursor(line, len(line)), line, len(line)) {
+			got = append(got, candidate.display+candidate.terminator)
+		}
+		sort.Strings(got)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("completing %q offered %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestEscapeCompletion(t *testing.T) {
+	tests := []struct {
+		text  string
+		quote byte
+		want  string
+	}{
+		{text: "my dir", want: `my\ dir`},
+		{text: "a&b(1).txt", want: `a\&b\(1\).txt`},
+		{text: "it's", quote: '\'', want: `it'\''s`},
+		{text: `say "$hi"`, quote: '"', want: `say \"\$hi\"`},
+		{text: "plain", quote: '"', want: "plain"},
+	}
+
+	for _, test := range tests {
+		if got := escapeCompletion(test.text, test.quote); got != test.want {
+			t.Errorf("escapeCompletion(%q, %q) = %q, want %q", test.text, test.quote, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func handlePrintf(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	var varName string
+	if len(words) >= 2 && words[0] == "-v" {
+		varName = words[1]
+		words = words[2:]
+	}
+	if len(words) > 0 && words[0] == "--" {
+		words = words[1:]
+	}
+
+	if len(words) == 0 {
+		outputStream(
+			strings.NewReader("printf: usage: printf [-v var] format [arguments]\n"),
+			redirectionTargets,
+			true,
+		)
+		shell.exitStatus = 2
+		return
+	}
+
+	output, errs := formatPrintf(words[0], words[1:])
+	if len(errs) > 0 {
+		var builder strings.Builder
+		for _, err := range errs {
+			builder.WriteString(fmt.Sprintf("printf: %v\n", err))
+		}
+		outputStream(strings.NewReader(builder.String()), redirectionTargets, true)
+		shell.exitStatus = 1
+	}
+
+	if varName != "" {
+		if !isValidVariableName(varName) {
+			outputStream(
+				strings.NewReader(fmt.Sprintf("printf: `%s': not a valid identifier\n", varName)),
+				redirectionTargets,
+				true,
+			)
+			shell.exitStatus = 1
+			return
+		}
+		setVariable(varName, output)
+		return
+	}
+
+	outputStream(strings.NewReader(output), redirectionTargets, false)
+}
+
+// formatPrintf applies format to args the way bash's printf does: the format
+// is reused until every argument has been consumed, missing arguments count
+// as empty strings or zero, and a \c inside %b stops all further output.
+func formatPrintf(format string, args []string) (string, []error) {
+	state := printfState{args: args}
+	var output strings.Builder
+
+	for {
+		consumedBefore := state.index
+		stop := state.expand(format, &output)
+
+		if stop || state.index >= len(state.args) || state.index == consumedBefore {
+			break
+		}
+	}
+
+	return output.String(), state.errs
+}
+
+type printfState struct {
+	args  []string
+	index int
+	errs  []error
+}
+
+func (p *printfState) nextArg() (string, bool) {
+	if p.index >= len(p.args) {
+		return "", false
+	}
+	arg := p.args[p.index]
+	p.index++
+	return arg, true
+}
+
+// expand writes one pass of format to output and reports whether output
+// should stop entirely (because of \c or an invalid directive).
+func (p *printfState) expand(format string, output *strings.Builder) (stop bool) {
+	for i := 0; i < len(format); i++ {
+		char := format[i]
+
+		if char == '\\' {
+			escaped, width, _ := readEscape(format[i:], false)
+			output.WriteString(escaped)
+			i += width - 1
+			continue
+		}
+
+		if char != '%' {
+			output.WriteByte(char)
+			continue
+		}
+
+		if i+1 < len(format) && format[i+1] == '%' {
+			output.WriteByte('%')
+			i++
+			continue
+		}
+
+		// parse %[flags][width][.precision]verb
+		start := i
+		i++
+		var spec strings.Builder
+		spec.WriteByte('%')
+
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) != -1 {
+			spec.WriteByte(format[i])
+			i++
+		}
+
+		if i < len(format) && format[i] == '*' {
+			arg, _ := p.nextArg()
+			spec.WriteString(strconv.FormatInt(p.parseInt(arg), 10))
+			i++
+		} else {
+			for i < len(format) && isDigit(format[i]) {
+				spec.WriteByte(format[i])
+				i++
+			}
+		}
+
+		if i < len(format) && format[i] == '.' {
+			spec.WriteByte('.')
+			i++
+			if i < len(format) && format[i] == '*' {
+				arg, _ := p.nextArg()
+				spec.WriteString(strconv.FormatInt(p.parseInt(arg), 10))
+				i++
+			} else {
+				for i < len(format) && isDigit(format[i]) {
+					spec.WriteByte(format[i])
+					i++
+				}
+			}
+		}
+
+		if i >= len(format) {
+			p.errs = append(p.errs, fmt.Errorf("`%s': missing format character", format[start:]))
+			return true
+		}
+
+		verb := format[i]
+		arg, _ := p.nextArg()
+
+		switch verb {
+		case 's':
+			output.WriteString(fmt.Sprintf(spec.String()+"s", arg))
+		case 'b':
+			expanded, stopOutput := interpretEscapes(arg, true)
+			output.WriteString(fmt.Sprintf(spec.String()+"s", expanded))
+			if stopOutput {
+				return true
+			}
+		case 'q':
+			output.WriteString(fmt.Sprintf(spec.String()+"s", shellQuote(arg)))
+		case 'c':
+			r, _ := utf8.DecodeRuneInString(arg)
+			if arg == "" {
+				output.WriteString(fmt.Sprintf(spec.String()+"s", ""))
+			} else {
+				output.WriteString(fmt.Sprintf(spec.String()+"c", r))
+			}
+		case 'd', 'i':
+			output.WriteString(fmt.Sprintf(spec.String()+"d", p.parseInt(arg)))
+		case 'u':
+			output.WriteString(fmt.Sprintf(spec.String()+"d", uint64(p.parseInt(arg))))
+		case 'x', 'X', 'o':
+			output.WriteString(fmt.Sprintf(spec.String()+string(verb), uint64(p.parseInt(arg))))
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			goVerb := verb
+			if goVerb == 'F' {
+				goVerb = 'f'
+			}
+			output.WriteString(fmt.Sprintf(spec.String()+string(goVerb), p.parseFloat(arg)))
+		default:
+			p.errs = append(p.errs, fmt.Errorf("%%%c: invalid format character", verb))
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseInt accepts decimal, 0x hex, leading-zero octal and the 'c form that
+// yields the character code of c.
+func (p *printfState) parseInt(arg string) int64 {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return 0
+	}
+
+	if arg[0] == '\'' || arg[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(arg[1:])
+		if r == utf8.RuneError {
+			return 0
+		}
+		return int64(r)
+	}
+
+	value, err := strconv.ParseInt(arg, 0, 64)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: invalid number", arg))
+		return 0
+	}
+	return value
+}
+
+func (p *printfState) parseFloat(arg string) float64 {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return 0
+	}
+
+	if arg[0] == '\'' || arg[0] == '"' {
+		return float64(p.parseInt(arg))
+	}
+
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("%s: invalid number", arg))
+		return 0
+	}
+	return value
+}
+
+// interpretEscapes expands backslash escapes the way echo -e and printf %b do.
+// The second return value is true when a \c was found, meaning the caller
+// must not print anything after the returned string.
+func interpretEscapes(input string, zeroPrefixedOctal bool) (string, bool) {
+	var output strings.Builder
+
+	for i := 0; i < len(input); i++ {
+		if input[i] != '\\' {
+			output.WriteByte(input[i])
+			continue
+		}
+
+		escaped, width, stop := readEscape(input[i:], zeroPrefixedOctal)
+		if stop {
+			return output.String(), true
+		}
+		output.WriteString(escaped)
+		i += width - 1
+	}
+
+	return output.String(), false
+}
+
+// readEscape decodes the escape sequence at the start of input (which begins
+// with a backslash) and returns the decoded text and the number of bytes read.
+// With zeroPrefixedOctal, octal escapes are written \0NNN as in echo -e.
+func readEscape(input string, zeroPrefixedOctal bool) (decoded string, width int, stop bool) {
+	if len(input) < 2 {
+		return "\\", 1, false
+	}
+
+	switch input[1] {
+	case 'a':
+		return "\a", 2, false
+	case 'b':
+		return "\b", 2, false
+	case 'c':
+		return "", 2, true
+	case 'e', 'E':
+		return "\x1b", 2, false
+	case 'f':
+		return "\f", 2, false
+	case 'n':
+		return "\n", 2, false
+	case 'r':
+		return "\r", 2, false
+	case 't':
+		return "\t", 2, false
+	case 'v':
+		return "\v", 2, false
+	case '\\':
+		return "\\", 2, false
+	case '"':
+		return "\"", 2, false
+	case '\'':
+		return "'", 2, false
+	case 'x':
+		value, digits := readDigits(input[2:], 16, 2)
+		if digits == 0 {
+			return "\\x", 2, false
+		}
+		return string([]byte{byte(value)}), 2 + digits, false
+	case 'u', 'U':
+		maxDigits := 4
+		if input[1] == 'U' {
+			maxDigits = 8
+		}
+		value, digits := readDigits(input[2:], 16, maxDigits)
+		if digits == 0 {
+			return input[:2], 2, false
+		}
+		return string(rune(value)), 2 + digits, false
+	}
+
+	if input[1] >= '0' && input[1] <= '7' {
+		offset := 1
+		if zeroPrefixedOctal && input[1] == '0' {
+			offset = 2
+		}
+		value, digits := readDigits(input[offset:], 8, 3)
+		return string([]byte{byte(value)}), offset + digits, false
+	}
+
+	return input[:2], 2, false
+}
+
+func readDigits(input string, base int, maxDigits int) (value int64, digits int) {
+	for digits < maxDigits && digits < len(input) {
+		digit, err := strconv.ParseInt(input[digits:digits+1], base, 64)
+		if err != nil {
+			break
+		}
+		value = value*int64(base) + digit
+		digits++
+	}
+	return value, digits
+}
+
+// shellQuote returns input quoted so it can be reused as shell input, matching
+// printf %q: plain words are left alone, control characters use $'...'.
+func shellQuote(input string) string {
+	if input == "" {
+		return "''"
+	}
+
+	hasControl := false
+	for _, r := range input {
+		if r < 0x20 || r == 0x7f {
+			hasControl = true
+			break
+		}
+	}
+
+	if hasControl {
+		var builder strings.Builder
+		builder.WriteString("$'")
+		for _, r := range input {
+			switch r {
+			case '\n':
+				builder.WriteString("\\n")
+			case '\t':
+				builder.WriteString("\\t")
+			case '\r':
+				builder.WriteString("\\r")
+			case '\x1b':
+				builder.WriteString("\\E")
+			case '\'', '\\':
+				builder.WriteRune('\\')
+				builder.WriteRune(r)
+			default:
+				if r < 0x20 || r == 0x7f {
+					builder.WriteString(fmt.Sprintf("\\%03o", r))
+				} else {
+					builder.WriteRune(r)
+				}
+			}
+		}
+		builder.WriteString("'")
+		return builder.String()
+	}
+
+	var builder strings.Builder
+	for _, r := range input {
+		if strings.ContainsRune(" \t!\"#$&'()*;<=>?[\\]^`{|}~", r) {
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+func isDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestFormatPrintf(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		args   []string
+		want   string
+		errors int
+	}{
+		{name: "plain text", format: `hello\n`, want: "hello\n"},
+		{name: "string", format: "[%s]", args: []string{"a b"}, want: "[a b]"},
+		{name: "width and left alignment", format: "[%5s|%-5s]", args: []string{"ab", "cd"}, want: "[   ab|cd   ]"},
+		{name: "precision cuts strings", format: "%.2s", args: []string{"abcdef"}, want: "ab"},
+		{name: "star width", format: "[%*d]", args: []string{"4", "7"}, want: "[   7]"},
+		{name: "integers", format: "%d %i %05d", args: []string{"42", "-3", "17"}, want: "42 -3 00017"},
+		{name: "hex and octal input", format: "%d %d", args: []string{"0x1f", "010"}, want: "31 8"},
+		{name: "character code", format: "%d", args: []string{"'A"}, want: "65"},
+		{name: "hex and octal output", format: "%x %X %o", args: []string{"255", "255", "8"}, want: "ff FF 10"},
+		{name: "floats", format: "%.2f %e", args: []string{"3.14159", "1500"}, want: "3.14 1.500000e+03"},
+		{name: "char", format: "%c%c", args: []string{"hello", ""}, want: "h"},
+		{name: "percent", format: "100%%", want: "100%"},
+		{name: "format is reused", format: "%s=%s\n", args: []string{"a", "1", "b", "2"}, want: "a=1\nb=2\n"},
+		{name: "missing arguments", format: "%s|%d|%s\n", args: []string{"x"}, want: "x|0|\n"},
+		{name: "no arguments still prints once", format: "%s-\n", want: "-\n"},
+		{name: "escapes in %b", format: "%b", args: []string{`a\tb\n`}, want: "a\tb\n"},
+		{name: "octal in %b", format: "%b", args: []string{`\0101`}, want: "A"},
+		{name: "\\c in %b stops output", format: "%b%s", args: []string{`one\ctwo`, "three"}, want: "one"},
+		{name: "quoted words", format: "%q %q %q", args: []string{"a b", "", "it's"}, want: `a\ b '' it\'s`},
+		{name: "quoted control characters", format: "%q", args: []string{"a\nb"}, want: `$'a\nb'`},
+		{name: "invalid number", format: "%d", args: []string{"abc"}, want: "0", errors: 1},
+		{name: "invalid directive", format: "a%zb", want: "a", errors: 1},
+		{name: "missing format character", format: "a%", want: "a", errors: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, errs := formatPrintf(test.format, test.args)
+			if got != test.want {
+				t.Errorf("formatPrintf(%q, %q) = %q, want %q", test.format, test.args, got, test.want)
+			}
+			if len(errs) != test.errors {
+				t.Errorf("formatPrintf(%q, %q) gave %d errors (%v), want %d", test.format, test.args, len(errs), errs, test.errors)
+			}
+		})
+	}
+}
+
+func TestInterpretEscapes(t *testing.T) {
+	tests := []struct {
+		input             string
+		zeroPrefixedOctal bool
+		want              string
+		stop              bool
+	}{
+		{input: `a\nb`, want: "a\nb"},
+		{input: `\x41\x4`, want: "A\x04"},
+		{input: `é`, want: "é"},
+		{input: `\0101`, zeroPrefixedOctal: true, want: "A"},
+		{input: `\101`, want: "A"},
+		{input: `\q`, want: `\q`},
+		{input: `trailing\`, want: `trailing\`},
+		{input: `stop\chere`, want: "stop", stop: true},
+	}
+
+	for _, test := range tests {
+		got, stop := interpretEscapes(test.input, test.zeroPrefixedOctal)
+		if got != test.want || stop != test.stop {
+			t.Errorf("interpretEscapes(%q, %v) = %q, %v, want %q, %v", test.input, test.zeroPrefixedOctal, got, stop, test.want, test.stop)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+)
+
+// processSubstitution is a running <(...) or >(...) command. The consuming
+// command sees parentEnd through a /dev/fd path.
+type processSubstitution struct {
+	cmd       *exec.Cmd
+	parentEnd *os.File
+	// path names parentEnd inside this process; children get their own path
+	path string
+}
+
+// activeSubstitutions are the substitutions of the command being run, so
+// that the code starting programs can hand the pipes over to them.
+var activeSubstitutions []*processSubstitution
+
+// startProcessSubstitutions starts every unquoted <(...) and >(...) found in
+// text and replaces each of them with the /dev/fd path of its pipe.
+func startProcessSubstitutions(text string) (string, []*processSubstitution) {
+	var builder strings.Builder
+	var substitutions []*processSubstitution
+	scanner := rawScanner{input: text}
+
+	for index := 0; index < len(text); index++ {
+		char := text[index]
+
+		isTopLevel := scanner.activeQuote == 0 && !scanner.escaped &&
+			scanner.parenDepth == 0 && scanner.braceDepth == 0
+		isWordStart := index == 0 || unicode.IsSpace(rune(text[index-1]))
+
+		if (char == '<' || char == '>') && isTopLevel && isWordStart &&
+			index+1 < len(text) && text[index+1] == '(' {
+
+			if end := matchingParen(text, index+1); end != -1 {
+				substitution, err := startProcessSubstitution(char, text[index+2:end])
+				if err != nil {
+					printErr(fmt.Sprintf("process substitution: %v\n", err))
+				} else {
+					builder.WriteString(substitution.path)
+					substitutions = append(substitutions, substitution)
+					index = end
+					continue
+				}
+			}
+		}
+
+		scanner.step(index)
+		builder.WriteByte(char)
+	}
+
+	return builder.String(), substitutions
+}
+
+// startProcessSubstitution runs commands in a subshell connected to a pipe.
+// For <(...) the subshell writes into the pipe and we keep the read side,
+// for >(...) it reads from the pipe and we keep the write side.
+func startProcessSubstitution(direction byte, commands string) (*processSubstitution, error) {
+	readSide, writeSide, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := subshellCommand(commands)
+	cmd.Stderr = os.Stderr
+
+	var parentEnd, childEnd *os.File
+	if direction == '<' {
+		cmd.Stdout = writeSide
+		parentEnd, childEnd = readSide, writeSide
+	} else {
+		cmd.Stdin = readSide
+		cmd.Stdout = os.Stdout
+		parentEnd, childEnd = writeSide, readSide
+	}
+
+	if err := cmd.Start(); err != nil {
+		readSide.Close()
+		writeSide.Close()
+		return nil, err
+	}
+	childEnd.Close()
+
+	return &processSubstitution{
+		cmd:       cmd,
+		parentEnd: parentEnd,
+		path:      fmt.Sprintf("/dev/fd/%d", parentEnd.Fd()),
+	}, nil
+}
+
+// finishProcessSubstitutions runs once the consuming command has exited. A
+// reader that stopped early gets SIGPIPE, a writer sees EOF.
+func finishProcessSubstitutions(substitutions []*processSubstitution) {
+	for _, substitution := range substitutions {
+		substitution.parentEnd.Close()
+	}
+
+	for _, substitution := range substitutions {
+		_ = substitution.cmd.Wait()
+	}
+}
+
+// attachSubstitutions passes the pipes of the substitutions used in cmd's
+// arguments to the child and rewrites their paths to the child's numbering.
+func attachSubstitutions(cmd *exec.Cmd) {
+	for _, substitution := range activeSubstitutions {
+		used := false
+		for _, arg := range cmd.Args[1:] {
+			if containsPath(arg, substitution.path) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			continue
+		}
+
+		// ExtraFiles[i] becomes file descriptor 3+i in the child
+		cmd.ExtraFiles = append(cmd.ExtraFiles, substitution.parentEnd)
+		childPath := fmt.Sprintf("/dev/fd/%d", 2+len(cmd.ExtraFiles))
+
+		for index := 1; index < len(cmd.Args); index++ {
+			cmd.Args[index] = replacePath(cmd.Args[index], substitution.path, childPath)
+		}
+	}
+}
+
+// containsPath reports whether arg contains path not followed by another
+// digit, so /dev/fd/5 does not match /dev/fd/51.
+func containsPath(arg, path string) bool {
+	return replacePath(arg, path, "") != arg
+}
+
+func replacePath(arg, path, replacement string) string {
+	var builder strings.Builder
+
+	for {
+		index := strings.Index(arg, path)
+		if index == -1 {
+			builder.WriteString(arg)
+			return builder.String()
+		}
+
+		end := index + len(path)
+		builder.WriteString(arg[:index])
+		if end < len(arg) && isDigit(arg[end]) {
+			builder.WriteString(path)
+		} else {
+			builder.WriteString(replacement)
+		}
+		arg = arg[end:]
+	}
+}
+
+func matchingParen(text string, open int) int {
+	scanner := rawScanner{input: text}
+
+	for index := open; index < len(text); index++ {
+		scanner.step(index)
+		if scanner.parenDepth == 0 && scanner.activeQuote == 0 {
+			return index
+		}
+	}
+
+	return -1
+}
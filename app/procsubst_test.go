@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessSubstitution(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.workingDirectory = t.TempDir()
+
+	if got := runCaptured("cat <(echo one) <(echo two)"); got != "one\ntwo\n" {
+		t.Errorf("cat <(echo one) <(echo two) printed %q", got)
+	}
+
+	runCommandLine("diff <(printf 'a\\n') <(printf 'a\\n')")
+	if shell.exitStatus != 0 {
+		t.Errorf("diff of two equal substitutions exited with %d", shell.exitStatus)
+	}
+	runCommandLine("diff <(echo a) <(echo b) > /dev/null")
+	if shell.exitStatus != 1 {
+		t.Errorf("diff of two different substitutions exited with %d", shell.exitStatus)
+	}
+
+	// the writer is waited for, so the file is complete once the line ran
+	runCommandLine("printf 'x\\ny\\n' > >(wc -l > count.txt)")
+	count, err := os.ReadFile(filepath.Join(shell.workingDirectory, "count.txt"))
+	if err != nil || strings.TrimSpace(string(count)) != "2" {
+		t.Errorf(">(wc -l > count.txt) wrote %q, %v", count, err)
+	}
+
+	// a builtin reads the pipe through its path in this process
+	if got := runCaptured("read line < <(echo from the pipe); echo $line"); got != "from the pipe\n" {
+		t.Errorf("read from a substitution printed %q", got)
+	}
+}
+
+func TestStartProcessSubstitutionsLeavesQuotesAlone(t *testing.T) {
+	for _, text := range []string{"echo '<(echo a)'", `echo "<(echo a)"`, `echo \<(echo a)`, "echo a<(b)", "cat < (x)"} {
+		got, substitutions := startProcessSubstitutions(text)
+		finishProcessSubstitutions(substitutions)
+		if got != text || len(substitutions) != 0 {
+			t.Errorf("startProcessSubstitutions(%q) = %q with %d substitutions, want it unchanged", text, got, len(substitutions))
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promptText is an expanded prompt string split the way readline needs
+// it. Readline redraws the prompt on every key and counts everything but
+// colors as visible text, so the lines before the last one and the other
+// escape sequences, such as the one setting the window title, are
+// printed once before the line is read.
+type promptText struct {
+	header string
+	prompt string
+}
+
+// primaryPrompt expands PS1, "$ " when it is not set.
+func primaryPrompt() promptText {
+	format, ok := os.LookupEnv("PS1")
+	if !ok {
+		format = "$ "
+	}
+	return expandPrompt(format)
+}
+
+// continuationPrompt expands PS2, "> " when it is not set.
+func continuationPrompt() promptText {
+	format, ok := os.LookupEnv("PS2")
+	if !ok {
+		format = "> "
+	}
+	return expandPrompt(format)
+}
+
+// runPromptCommand runs PROMPT_COMMAND before the prompt is shown, or each
+// of its elements when it is an array. $? is left as the last command set
+// it, for PS1 and for the next command.
+func runPromptCommand() {
+	commands := shellArrays["PROMPT_COMMAND"]
+	if command, ok := os.LookupEnv("PROMPT_COMMAND"); ok {
+		commands = []string{command}
+	}
+
+	status := shell.exitStatus
+	for _, command := range commands {
+		if strings.TrimSpace(command) != "" {
+			runCommandLine(command)
+		}
+	}
+	shell.exitStatus = status
+}
+
+// traceCommand prints words after PS4 when set -x is on.
+func traceCommand(words []string) {
+	if !setOptions["xtrace"] || len(words) == 0 {
+		return
+	}
+
+	format, ok := os.LookupEnv("PS4")
+	if !ok {
+		format = "+ "
+	}
+	text := expandPrompt(format)
+
+	quoted := make([]string, len(words))
+	for index, word := range words {
+		quoted[index] = word
+		if word == "" || strings.ContainsAny(word, " \t\n'\"\\$|&;<>()*?[]{}`!#~") {
+			quoted[index] = quoteWord(word)
+		}
+	}
+	printErr(text.header + text.prompt + strings.Join(quoted, " ") + "\n")
+}
+
+// expandPrompt replaces the bash prompt escapes of format and the
+// variables it references. Text between \[ and \] takes no room on the
+// screen: colors stay in the prompt, anything else goes to the header.
+func expandPrompt(format string) promptText {
+	var prompt, control, invisible strings.Builder
+	inInvisible := false
+	now := time.Now()
+
+	write := func(text string) {
+		if inInvisible {
+			invisible.WriteString(text)
+		} else {
+			prompt.WriteString(text)
+		}
+	}
+
+	// endInvisible sorts what was between \[ and \] into colors and the
+	// rest
+	endInvisible := func() {
+		text := invisible.String()
+		for text != "" {
+			sequence, rest := splitEscapeSequence(text)
+			if isColorSequence(sequence) {
+				prompt.WriteString(sequence)
+			} else {
+				control.WriteString(sequence)
+			}
+			text = rest
+		}
+		invisible.Reset()
+		inInvisible = false
+	}
+
+	for index := 0; index < len(format); index++ {
+		char := format[index]
+
+		if char == '$' {
+			value, end := expandVariableAt(format, index)
+			write(value)
+			index = end - 1
+			continue
+		}
+
+		if char != '\\' || index+1 == len(format) {
+			write(format[index : index+1])
+			continue
+		}
+
+		index++
+		switch escape := format[index]; escape {
+		case 'u':
+			write(currentUserName())
+		case 'h', 'H':
+			host, _ := os.Hostname()
+			if escape == 'h' {
+				host, _, _ = strings.Cut(host, ".")
+			}
+			write(host)
+		case 'w':
+			write(abbreviateHome(currentDirectory()))
+		case 'W':
+			directory := abbreviateHome(currentDirectory())
+			if directory != "~" && directory != "/" {
+				directory = filepath.Base(directory)
+			}
+			write(directory)
+		case '$':
+			if os.Geteuid() == 0 {
+				write("#")
+			} else {
+				write("$")
+			}
+		case 't':
+			write(now.Format("15:04:05"))
+		case 'T':
+			write(now.Format("03:04:05"))
+		case '@':
+			write(now.Format("03:04 PM"))
+		case 'A':
+			write(now.Format("15:04"))
+		case 'd':
+			write(now.Format("Mon Jan 02"))
+		case 'D':
+			// \D{format} is strftime, an empty format the locale's time
+			if index+1 < len(format) && format[index+1] == '{' {
+				if closing := strings.IndexByte(format[index+1:], '}'); closing != -1 {
+					timeFormat := format[index+2 : index+1+closing]
+					if timeFormat == "" {
+						timeFormat = "%X"
+					}
+					write(strftime(timeFormat, now))
+					index += 1 + closing
+					continue
+				}
+			}
+			write(`\D`)
+		case 'j':
+			// there is no job control, so there are never any jobs
+			write("0")
+		case '?':
+			write(strconv.Itoa(shell.exitStatus))
+		case '!':
+			write(strconv.Itoa(shell.history.base + len(shell.history.memory) + 1))
+		case 's':
+			write(filepath.Base(os.Args[0]))
+		case 'g':
+			// not a bash escape: the branch and state of the git
+			// repository, see gitPromptSegment
+			write(gitPromptSegment())
+		case 'n':
+			write("\n")
+		case 'r':
+			write("\r")
+		case 'a':
+			write("\a")
+		case 'e':
+			write("\x1b")
+		case '\\':
+			write(`\`)
+		case '[':
+			inInvisible = true
+		case ']':
+			endInvisible()
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// \nnn is the character with that octal code
+			end := index
+			for end < len(format) && end < index+3 && format[end] >= '0' && format[end] <= '7' {
+				end++
+			}
+			code, _ := strconv.ParseUint(format[index:end], 8, 8)
+			write(string([]byte{byte(code)}))
+			index = end - 1
+		default:
+			write(`\` + string(escape))
+		}
+	}
+
+	endInvisible()
+
+	text := prompt.String()
+	// readline reads past an escape at the very end of the prompt
+	text = strings.TrimRight(text, "\x1b")
+
+	header := control.String()
+	if newline := strings.LastIndexByte(text, '\n'); newline != -1 {
+		header += strings.ReplaceAll(text[:newline+1], "\n", "\r\n")
+		text = text[newline+1:]
+	}
+
+	return promptText{header: header, prompt: text}
+}
+
+// splitEscapeSequence cuts the escape sequence text starts with, or its
+// first character when it does not start with one.
+func splitEscapeSequence(text string) (string, string) {
+	if len(text) < 2 || text[0] != '\x1b' {
+		return text[:1], text[1:]
+	}
+
+	switch text[1] {
+	case '[':
+		// a CSI sequence ends with a byte from @ to ~
+		for end := 2; end < len(text); end++ {
+			if text[end] >= '@' && text[end] <= '~' {
+				return text[:end+1], text[end+1:]
+			}
+		}
+	case ']':
+		// an OSC sequence ends with BEL or ESC \
+		for end := 2; end < len(text); end++ {
+			if text[end] == '\a' {
+				return text[:end+1], text[end+1:]
+			}
+			if text[end] == '\x1b' && end+1 < len(text) && text[end+1] == '\\' {
+				return text[:end+2], text[end+2:]
+			}
+		}
+	default:
+		return text[:2], text[2:]
+	}
+	return text, ""
+}
+
+func isColorSequence(sequence string) bool {
+	return strings.HasPrefix(sequence, "\x1b[") && strings.HasSuffix(sequence, "m")
+}
+
+func currentUserName() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if account, err := user.Current(); err == nil {
+		return account.Username
+	}
+	return strconv.Itoa(os.Getuid())
+}
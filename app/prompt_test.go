@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandPrompt(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USER", "ada")
+	t.Setenv("NAME", "world")
+	shell.workingDirectory = filepath.Join(home, "src", "shell")
+	shell.exitStatus = 3
+	shell.history = &historyCache{base: 10, memory: []historyEntry{{line: "ls"}, {line: "pwd"}}}
+
+	dollar := "$"
+	if os.Geteuid() == 0 {
+		dollar = "#"
+	}
+
+	tests := []struct {
+		format string
+		header string
+		prompt string
+	}{
+		{format: `\u:\w\$ `, prompt: "ada:~/src/shell" + dollar + " "},
+		{format: `\W> `, prompt: "shell> "},
+		{format: `[\?] \! `, prompt: "[3] 13 "},
+		{format: `$NAME ${NAME}s \\ \q`, prompt: `world worlds \ \q`},
+		{format: `\101\142c`, prompt: "Abc"},
+		{format: `\j jobs`, prompt: "0 jobs"},
+		// only the last line is readline's prompt, the others are printed first
+		{format: `\w\n\$ `, header: "~/src/shell\r\n", prompt: dollar + " "},
+		// colors stay in the prompt, other invisible sequences go first
+		{format: `\[\e[32m\]ok\[\e[0m\] `, prompt: "\x1b[32mok\x1b[0m "},
+		{format: `\[\e]0;title\a\]$ `, header: "\x1b]0;title\a", prompt: "$ "},
+		{format: `trailing\e`, prompt: "trailing"},
+	}
+
+	for _, test := range tests {
+		got := expandPrompt(test.format)
+		if got.header != test.header || got.prompt != test.prompt {
+			t.Errorf("expandPrompt(%q) = %q + %q, want %q + %q", test.format, got.header, got.prompt, test.header, test.prompt)
+		}
+	}
+
+	// \w at the top of the home directory and at the root
+	shell.workingDirectory = home
+	if got := expandPrompt(`\w \W`).prompt; got != "~ ~" {
+		t.Errorf(`\w \W in HOME expanded to %q`, got)
+	}
+	shell.workingDirectory = "/"
+	if got := expandPrompt(`\w \W`).prompt; got != "/ /" {
+		t.Errorf(`\w \W in / expanded to %q`, got)
+	}
+}
+
+func TestExpandPromptTimes(t *testing.T) {
+	before := time.Now()
+	got := expandPrompt(`\D{%Y-%m-%d} \A`).prompt
+	after := time.Now()
+
+	// the clock may tick between the two readings
+	for _, moment := range []time.Time{before, after} {
+		if got == moment.Format("2006-01-02 15:04") {
+			return
+		}
+	}
+	t.Errorf(`\D{%%Y-%%m-%%d} \A expanded to %q at %v`, got, before)
+}
+
+func TestPromptDefaults(t *testing.T) {
+	unsetenv(t, "PS1")
+	unsetenv(t, "PS2")
+	if got := primaryPrompt().prompt; got != "$ " {
+		t.Errorf("without PS1 the prompt is %q", got)
+	}
+	if got := continuationPrompt().prompt; got != "> " {
+		t.Errorf("without PS2 the continuation prompt is %q", got)
+	}
+
+	t.Setenv("PS2", `\u... `)
+	t.Setenv("USER", "ada")
+	if got := continuationPrompt().prompt; got != "ada... " {
+		t.Errorf("PS2 expanded to %q", got)
+	}
+}
+
+func TestTraceCommandUsesPS4(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	setOptions["xtrace"] = true
+	t.Setenv("PS4", `+\? `)
+	shell.exitStatus = 0
+
+	readSide, writeSide, _ := os.Pipe()
+	stderr := os.Stderr
+	os.Stderr = writeSide
+	traceCommand([]string{"echo", "two words", ""})
+	os.Stderr = stderr
+	writeSide.Close()
+
+	output := make([]byte, 256)
+	n, _ := readSide.Read(output)
+	if got := string(output[:n]); got != "+0 echo 'two words' ''\n" {
+		t.Errorf("set -x traced %q", got)
+	}
+}
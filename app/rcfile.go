@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rcFile is the file an interactive shell runs before its first prompt,
+// SHELLRC or ~/.shellrc. It keeps settings such as set -o vi and the keys
+// bound with bind between sessions.
+func rcFile() string {
+	if path := os.Getenv("SHELLRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".shellrc")
+}
+
+// runRCFile runs the lines of the rc file one by one, skipping blank lines
+// and comments. A missing rc file is not an error.
+func runRCFile() {
+	path := rcFile()
+	if path == "" {
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			printErr(fmt.Sprintf("%v\n", err))
+		}
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		runCommandLine(line)
+	}
+	shell.exitStatus = 0
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	"golang.org/x/sys/unix"
+)
+
+var errReadTimeout = errors.New("read timed out")
+var errReadInterrupted = errors.New("read interrupted")
+
+type readOptions struct {
+	raw        bool
+	silent     bool
+	prompt     string
+	hasTimeout bool
+	timeout    time.Duration
+	// count is the number of characters to read, -1 reads up to the delimiter
+	count     int
+	delimiter byte
+	arrayName string
+	names     []string
+}
+
+func handleRead(rl *readline.Instance, args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	options, err := parseReadOptions(filterAndJoinArgs(args[1:]))
+	if err != nil {
+		outputStream(
+			strings.NewReader(fmt.Sprintf("read: %v\n", err)),
+			redirectionTargets,
+			true,
+		)
+		shell.exitStatus = 2
+		return
+	}
+
+	var input string
+
+	switch {
+	case redirectionTargets.inputRedirect != "":
+		absPath, err := absolutePath(redirectionTargets.inputRedirect)
+		if err != nil {
+			outputStream(
+				strings.NewReader(fmt.Sprintf("read: %v\n", err)),
+				redirectionTargets,
+				true,
+			)
+			shell.exitStatus = 1
+			return
+		}
+		file, openErr := os.Open(absPath)
+		if openErr != nil {
+			outputStream(
+				strings.NewReader(fmt.Sprintf("read: %v\n", openErr)),
+				redirectionTargets,
+				true,
+			)
+			shell.exitStatus = 1
+			return
+		}
+		input, err = readDelimited(bufio.NewReader(file), -1, options, nil)
+		file.Close()
+
+	case readline.IsTerminal(int(os.Stdin.Fd())):
+		input, err = readFromTerminal(rl, options)
+
+	default:
+		if options.prompt != "" {
+			// bash only shows the prompt when reading from a terminal
+			options.prompt = ""
+		}
+		input, err = readDelimited(stdinReader, int(os.Stdin.Fd()), options, nil)
+	}
+
+	if err == errReadInterrupted {
+		shell.exitStatus = 130
+		return
+	}
+
+	// like bash, read fails at end of file and on timeout, but whatever
+	// was read before that is still assigned
+	switch {
+	case err == errReadTimeout:
+		shell.exitStatus = 142
+	case err != nil:
+		shell.exitStatus = 1
+	}
+
+	assignReadResult(input, options)
+}
+
+func parseReadOptions(words []string) (options readOptions, err error) {
+	options.count = -1
+	options.delimiter = '\n'
+
+	for len(words) > 0 {
+		word := words[0]
+		if word == "--" {
+			words = words[1:]
+			break
+		}
+		if len(word) < 2 || word[0] != '-' {
+			break
+		}
+		words = words[1:]
+
+		for i := 1; i < len(word); i++ {
+			flag := word[i]
+
+			switch flag {
+			case 'r':
+				options.raw = true
+				continue
+			case 's':
+				options.silent = true
+				continue
+			case 'p', 't', 'n', 'd', 'a':
+			default:
+				return options, fmt.Errorf("-%c: invalid option", flag)
+			}
+
+			// the value is either glued to the flag (-n3) or the next word
+			value := word[i+1:]
+			if value == "" {
+				if len(words) == 0 {
+					return options, fmt.Errorf("-%c: option requires an argument", flag)
+				}
+				value = words[0]
+				words = words[1:]
+			}
+
+			switch flag {
+			case 'p':
+				options.prompt = value
+			case 't':
+				seconds, err := strconv.ParseFloat(value, 64)
+				if err != nil || seconds < 0 {
+					return options, fmt.Errorf("%s: invalid timeout specification", value)
+				}
+				options.hasTimeout = true
+				options.timeout = time.Duration(seconds * float64(time.Second))
+			case 'n':
+				count, err := strconv.Atoi(value)
+				if err != nil || count < 0 {
+					return options, fmt.Errorf("%s: invalid number", value)
+				}
+				options.count = count
+			case 'd':
+				// like bash, -d '' reads up to a NUL byte
+				options.delimiter = 0
+				if value != "" {
+					options.delimiter = value[0]
+				}
+			case 'a':
+				options.arrayName = value
+			}
+			break
+		}
+	}
+
+	options.names = words
+
+	for _, name := range append([]string{options.arrayName}, options.names...) {
+		if name != "" && !isValidVariableName(name) {
+			return options, fmt.Errorf("`%s': not a valid identifier", name)
+		}
+	}
+
+	return options, nil
+}
+
+// readFromTerminal reads a plain line through the readline instance so the
+// user keeps line editing, and falls back to reading the raw terminal when
+// a timeout, a character count or a custom delimiter is needed.
+func readFromTerminal(rl *readline.Instance, options readOptions) (string, error) {
+	if rl != nil && !options.hasTimeout && options.count == -1 && options.delimiter == '\n' {
+		var line string
+		var err error
+
+		shell.terminal.resume()
+
+		if options.silent {
+			var password []byte
+			password, err = rl.ReadPassword(options.prompt)
+			line = string(password)
+		} else {
+			previousPrompt := shell.prompt
+			shell.prompt = options.prompt
+			rl.SetPrompt(shell.prompt)
+			rl.HistoryDisable()
+			line, err = rl.Readline()
+			rl.HistoryEnable()
+			shell.prompt = previousPrompt
+			rl.SetPrompt(shell.prompt)
+		}
+
+		if err == readline.ErrInterrupt {
+			return "", errReadInterrupted
+		}
+
+		// a trailing backslash continues the line unless -r is given
+		for err == nil && !options.raw && strings.HasSuffix(line, "\\") {
+			var next string
+			next, err = rl.Readline()
+			line = line[:len(line)-1] + next
+		}
+
+		return line, err
+	}
+
+	fd := int(os.Stdin.Fd())
+	state, err := readline.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer readline.Restore(fd, state)
+
+	printErr(options.prompt)
+
+	echo := func(data string) {
+		if !options.silent {
+			printErr(data)
+		}
+	}
+
+	// the bytes typed after the delimiter are left in the terminal for
+	// whatever reads it next
+	input, err := readDelimited(bufio.NewReader(singleByteReader{os.Stdin}), fd, options, echo)
+
+	// raw mode does not translate newlines, so we move to the next line ourselves
+	if err != errReadInterrupted {
+		printErr("\r\n")
+	}
+	return input, err
+}
+
+// singleByteReader reads one byte at a time, so that a bufio.Reader on top
+// of it never takes more from the file than is asked of it.
+type singleByteReader struct {
+	file *os.File
+}
+
+func (reader singleByteReader) Read(buffer []byte) (int, error) {
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+	return reader.file.Read(buffer[:1])
+}
+
+// readDelimited reads from reader until the delimiter, the character count,
+// the timeout or EOF is reached. When fd is not -1 it is polled so that the
+// timeout also applies while waiting for input. echo is set when reading a
+// raw terminal, in which case the line editing keys are handled here.
+func readDelimited(reader *bufio.Reader, fd int, options readOptions, echo func(string)) (string, error) {
+	var data []byte
+	escapes := 0
+	deadline := time.Now().Add(options.timeout)
+
+	// -t 0 only reports whether input is available
+	if options.hasTimeout && options.timeout == 0 {
+		if reader.Buffered() > 0 || (fd != -1 && pollInput(fd, 0)) {
+			return "", nil
+		}
+		return "", errReadTimeout
+	}
+
+	nextByte := func() (byte, error) {
+		if options.hasTimeout && fd != -1 && reader.Buffered() == 0 {
+			if !pollInput(fd, time.Until(deadline)) {
+				return 0, errReadTimeout
+			}
+		}
+		return reader.ReadByte()
+	}
+
+	for options.count != 0 {
+		char, err := nextByte()
+		if err != nil {
+			return string(data), err
+		}
+
+		if echo != nil {
+			switch char {
+			case '\r':
+				char = '\n'
+			case 0x03:
+				printErr("^C\r\n")
+				return "", errReadInterrupted
+			case 0x04:
+				if len(data) == 0 {
+					return "", io.EOF
+				}
+				continue
+			case 0x7f, 0x08:
+				if len(data) > 0 {
+					_, size := utf8.DecodeLastRune(data)
+					data = data[:len(data)-size]
+					// an odd backslash left at the end escaped the character
+					if trailingBackslashes(string(data))%2 == 1 {
+						data = data[:len(data)-1]
+						escapes--
+					}
+					echo("\b \b")
+				}
+				continue
+			}
+		}
+
+		if char == '\\' && !options.raw {
+			escaped, err := nextByte()
+			if err != nil {
+				return string(data), err
+			}
+			// backslash-newline is a line continuation
+			if escaped == '\n' || (echo != nil && escaped == '\r') {
+				continue
+			}
+			data = append(data, '\\', escaped)
+			escapes++
+			if echo != nil {
+				echo(string(escaped))
+			}
+		} else {
+			if char == options.delimiter {
+				break
+			}
+			data = append(data, char)
+			if echo != nil {
+				echo(string(char))
+			}
+		}
+
+		if options.count > 0 && utf8.Valid(data) && utf8.RuneCount(data)-escapes >= options.count {
+			break
+		}
+	}
+
+	return string(data), nil
+}
+
+func pollInput(fd int, timeout time.Duration) bool {
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, int(timeout.Milliseconds()))
+		if err == unix.EINTR {
+			continue
+		}
+		return err == nil && n > 0
+	}
+}
+
+func assignReadResult(input string, options readOptions) {
+	ifs, isSet := os.LookupEnv("IFS")
+	if !isSet {
+		ifs = " \t\n"
+	}
+
+	// the backslashes are only removed once the fields are split, so that
+	// an escaped IFS character does not separate them
+	unescape := func(fields []string) []string {
+		if !options.raw {
+			for index, field := range fields {
+				fields[index] = removeBackslashes(field)
+			}
+		}
+		return fields
+	}
+
+	if options.arrayName != "" {
+		setArray(options.arrayName, unescape(splitFields(input, ifs, 0, !options.raw)))
+		return
+	}
+
+	if len(options.names) == 0 {
+		setVariable("REPLY", unescape([]string{input})[0])
+		return
+	}
+
+	fields := unescape(splitFields(input, ifs, len(options.names), !options.raw))
+	for index, name := range options.names {
+		value := ""
+		if index < len(fields) {
+			value = fields[index]
+		}
+		setVariable(name, value)
+	}
+}
+
+// splitFields splits input on the characters of ifs the way read does:
+// runs of IFS whitespace count as one separator, other IFS characters
+// delimit exactly one field, and the last of maxFields fields receives
+// the rest of the line. maxFields of 0 means no limit. When escaped is set
+// a character after a backslash never separates fields, and the
+// backslashes are left for the caller to remove.
+func splitFields(input string, ifs string, maxFields int, escaped bool) []string {
+	if ifs == "" {
+		if input == "" {
+			return nil
+		}
+		return []string{input}
+	}
+
+	var whitespace, delimiters string
+	for _, char := range ifs {
+		if char == ' ' || char == '\t' || char == '\n' {
+			whitespace += string(char)
+		} else {
+			delimiters += string(char)
+		}
+	}
+
+	// separatorIndex finds the first IFS character of text that is not
+	// escaped
+	separatorIndex := func(text string) int {
+		for index := 0; index < len(text); index++ {
+			if escaped && text[index] == '\\' {
+				index++
+				continue
+			}
+			if strings.IndexByte(ifs, text[index]) != -1 {
+				return index
+			}
+		}
+		return -1
+	}
+
+	var fields []string
+	rest := strings.TrimLeft(input, whitespace)
+
+	for rest != "" && (maxFields == 0 || len(fields) < maxFields-1) {
+		index := separatorIndex(rest)
+		if index == -1 {
+			break
+		}
+
+		fields = append(fields, rest[:index])
+		rest = strings.TrimLeft(rest[index:], whitespace)
+		if rest != "" && strings.ContainsRune(delimiters, rune(rest[0])) {
+			rest = strings.TrimLeft(rest[1:], whitespace)
+		}
+	}
+
+	// an escaped whitespace character at the end is kept
+	trimmed := strings.TrimRight(rest, whitespace)
+	if escaped && len(trimmed) < len(rest) && trailingBackslashes(trimmed)%2 == 1 {
+		trimmed = rest[:len(trimmed)+1]
+	}
+	rest = trimmed
+
+	// like bash, the last field loses a delimiter that ends the line when
+	// no other separator is left in it
+	if index := separatorIndex(rest); index != -1 {
+		if tail := strings.Trim(rest[index:], whitespace); len(tail) == 1 && strings.Contains(delimiters, tail) {
+			rest = rest[:index]
+		}
+	}
+
+	if rest != "" {
+		fields = append(fields, rest)
+	}
+
+	return fields
+}
+
+// trailingBackslashes counts the backslashes text ends with.
+func trailingBackslashes(text string) int {
+	return len(text) - len(strings.TrimRight(text, "\\"))
+}
+
+func removeBackslashes(input string) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(input); i++ {
+		if input[i] == '\\' && i+1 < len(input) {
+			i++
+		}
+		builder.WriteByte(input[i])
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSplitFields(t *testing.T) {
+	// the expected fields are what bash's read assigns
+	tests := []struct {
+		input     string
+		ifs       string
+		maxFields int
+		raw       bool
+		want      []string
+	}{
+		{input: "  one  two\tthree ", ifs: " \t\n", want: []string{"one", "two", "three"}},
+		{input: "one two three", ifs: " \t\n", maxFields: 2, want: []string{"one", "two three"}},
+		{input: "one two  ", ifs: " \t\n", maxFields: 3, want: []string{"one", "two"}},
+		{input: "a,,b,", ifs: ",", want: []string{"a", "", "b"}},
+		{input: "x:y:z:", ifs: ":", maxFields: 2, want: []string{"x", "y:z:"}},
+		{input: "x:y:", ifs: ":", maxFields: 2, want: []string{"x", "y"}},
+		{input: "  x : y  z  ", ifs: ": ", maxFields: 3, want: []string{"x", "y", "z"}},
+		{input: "  x : y  z  ", ifs: ": ", maxFields: 2, want: []string{"x", "y  z"}},
+		{input: "  kept  ", ifs: "", maxFields: 1, want: []string{"  kept  "}},
+		{input: "", ifs: " \t\n", want: nil},
+		{input: `a\ b c`, ifs: " \t\n", want: []string{`a\ b`, "c"}},
+		{input: `a\ b c`, ifs: " \t\n", raw: true, want: []string{`a\`, "b", "c"}},
+		{input: `a\:b:c`, ifs: ":", maxFields: 2, want: []string{`a\:b`, "c"}},
+		{input: `end\ `, ifs: " \t\n", maxFields: 1, want: []string{`end\ `}},
+	}
+
+	for _, test := range tests {
+		if got := splitFields(test.input, test.ifs, test.maxFields, !test.raw); !slices.Equal(got, test.want) {
+			t.Errorf("splitFields(%q, %q, %d) = %q, want %q", test.input, test.ifs, test.maxFields, got, test.want)
+		}
+	}
+}
+
+func TestReadFromFile(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	shell.workingDirectory = t.TempDir()
+	input := "one\\ two three\nfirst:second:third\n"
+	os.WriteFile(filepath.Join(shell.workingDirectory, "input.txt"), []byte(input), 0644)
+
+	tests := []struct {
+		line string
+		ifs  string
+		want string
+	}{
+		{line: "read a b < input.txt; echo \"[$a][$b]\"", want: "[one two][three]\n"},
+		{line: "read -r a b < input.txt; echo \"[$a][$b]\"", want: "[one\\][two three]\n"},
+		{line: "read < input.txt; echo \"[$REPLY]\"", want: "[one two three]\n"},
+		{line: "read -a words < input.txt; echo ${#words[@]} ${words[1]}", want: "2 three\n"},
+		{line: "read -d : a < input.txt; echo \"[$a]\"", want: "[one two three\nfirst]\n"},
+		{line: "read -n 3 a < input.txt; echo \"[$a]\"", want: "[one]\n"},
+		{line: "{ read a; read b c; } < input.txt; echo \"[$b][$c]\"", ifs: ":", want: "[first][second:third]\n"},
+	}
+
+	for _, test := range tests {
+		if test.ifs != "" {
+			t.Setenv("IFS", test.ifs)
+		} else {
+			os.Unsetenv("IFS")
+		}
+		if got := runCaptured(test.line); got != test.want {
+			t.Errorf("%q with IFS=%q printed %q, want %q", test.line, test.ifs, got, test.want)
+		}
+	}
+}
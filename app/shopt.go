@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// shellOptions are the settings changed with shopt -s and shopt -u.
+var shellOptions = map[string]bool{
+	// histappend appends to HISTFILE on exit instead of rewriting it
+	"histappend": false,
+	// histincappend appends every command to HISTFILE as soon as it ran
+	"histincappend": false,
+	// histshare is histincappend plus merging the commands other sessions
+	// appended after every command
+	"histshare": false,
+	// completefuzzy offers the names containing the typed characters in
+	// order when no name starts with them
+	"completefuzzy": false,
+	// completemenu shows the candidates of the second Tab in a menu
+	// instead of listing them
+	"completemenu": false,
+	// highlight colors the command line while it is typed. It is off
+	// unless shopt -s highlight turns it on, in ~/.shellrc for instance
+	"highlight": false,
+	// autosuggest shows the rest of the latest matching history entry
+	// after the cursor. Like highlight it has to be turned on
+	"autosuggest": false,
+}
+
+func handleShopt(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	var set, unset, printable, quiet bool
+	for len(words) > 0 && len(words[0]) > 1 && words[0][0] == '-' {
+		for _, flag := range words[0][1:] {
+			switch flag {
+			case 's':
+				set = true
+			case 'u':
+				unset = true
+			case 'p':
+				printable = true
+			case 'q':
+				quiet = true
+			default:
+				outputStream(
+					strings.NewReader(fmt.Sprintf("shopt: -%c: invalid option\n", flag)),
+					redirectionTargets,
+					true,
+				)
+				shell.exitStatus = 2
+				return
+			}
+		}
+		words = words[1:]
+	}
+
+	if set && unset {
+		outputStream(
+			strings.NewReader("shopt: cannot set and unset shell options simultaneously\n"),
+			redirectionTargets,
+			true,
+		)
+		shell.exitStatus = 1
+		return
+	}
+
+	names := words
+	if len(names) == 0 {
+		for name := range shellOptions {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+
+	var result strings.Builder
+	for _, name := range names {
+		enabled, ok := shellOptions[name]
+		if !ok {
+			outputStream(
+				strings.NewReader(fmt.Sprintf("shopt: %s: invalid shell option name\n", name)),
+				redirectionTargets,
+				true,
+			)
+			shell.exitStatus = 1
+			continue
+		}
+
+		switch {
+		case set || unset:
+			// with no names, -s and -u list the options that are on or off
+			if len(words) == 0 {
+				if enabled == set {
+					result.WriteString(formatShoptLine(name, enabled, printable))
+				}
+				continue
+			}
+			shellOptions[name] = set
+		case quiet:
+			if !enabled {
+				shell.exitStatus = 1
+			}
+		default:
+			if !enabled {
+				shell.exitStatus = 1
+			}
+			result.WriteString(formatShoptLine(name, enabled, printable))
+		}
+	}
+
+	outputStream(strings.NewReader(result.String()), redirectionTargets, false)
+}
+
+func formatShoptLine(name string, enabled bool, printable bool) string {
+	if printable {
+		flag := "-u"
+		if enabled {
+			flag = "-s"
+		}
+		return fmt.Sprintf("shopt %s %s\n", flag, name)
+	}
+
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	return fmt.Sprintf("%-15s\t%s\n", name, state)
+}
+
+// setOptions are the settings changed with set -o and set +o, or with the
+// single letter flags in setFlags.
+var setOptions = map[string]bool{
+	// xtrace prints every command before it runs, after PS4
+	"xtrace": false,
+	// emacs and vi are the editing modes of the command line, exactly one
+	// of them is on
+	"emacs": true,
+	"vi":    false,
+}
+
+var setFlags = map[rune]string{
+	'x': "xtrace",
+}
+
+// handleSet changes the set options. Without arguments it lists the
+// variables, set -o lists the options and set +o prints the commands that
+// restore them.
+func handleSet(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+
+	fail := func(message string, status int) {
+		outputStream(strings.NewReader("set: "+message+"\n"), redirectionTargets, true)
+		shell.exitStatus = status
+	}
+
+	if len(words) == 0 {
+		variables := os.Environ()
+		sort.Strings(variables)
+
+		var result strings.Builder
+		for _, variable := range variables {
+			name, value, _ := strings.Cut(variable, "=")
+			result.WriteString(fmt.Sprintf("%s=%s\n", name, quoteWord(value)))
+		}
+		outputStream(strings.NewReader(result.String()), redirectionTargets, false)
+		return
+	}
+
+	for len(words) > 0 {
+		word := words[0]
+		words = words[1:]
+
+		if word == "--" || len(word) < 2 || (word[0] != '-' && word[0] != '+') {
+			fail(fmt.Sprintf("%s: positional parameters are not supported", word), 2)
+			return
+		}
+		enable := word[0] == '-'
+
+		for _, flag := range word[1:] {
+			if flag != 'o' {
+				name, ok := setFlags[flag]
+				if !ok {
+					fail(fmt.Sprintf("%c%c: invalid option", word[0], flag), 2)
+					return
+				}
+				setOption(name, enable)
+				continue
+			}
+
+			// -o without a name lists the options
+			if len(words) == 0 {
+				outputStream(strings.NewReader(formatSetOptions(!enable)), redirectionTargets, false)
+				continue
+			}
+			name := words[0]
+			words = words[1:]
+			if _, ok := setOptions[name]; !ok {
+				fail(fmt.Sprintf("%s: invalid option name", name), 1)
+				return
+			}
+			setOption(name, enable)
+		}
+	}
+}
+
+// setOption turns a set option on or off. Turning one editing mode off
+// turns the other one on, as the line is always edited in one of them.
+func setOption(name string, enable bool) {
+	switch name {
+	case "vi":
+		setEditingMode(enable)
+	case "emacs":
+		setEditingMode(!enable)
+	default:
+		setOptions[name] = enable
+	}
+}
+
+func formatSetOptions(reusable bool) string {
+	var names []string
+	for name := range setOptions {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		enabled := setOptions[name]
+		switch {
+		case reusable && enabled:
+			builder.WriteString(fmt.Sprintf("set -o %s\n", name))
+		case reusable:
+			builder.WriteString(fmt.Sprintf("set +o %s\n", name))
+		case enabled:
+			builder.WriteString(fmt.Sprintf("%-15s\ton\n", name))
+		default:
+			builder.WriteString(fmt.Sprintf("%-15s\toff\n", name))
+		}
+	}
+	return builder.String()
+}
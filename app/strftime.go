@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// strftime formats t with the C strftime conversions that bash users put in
+// HISTTIMEFORMAT. Unknown conversions are copied unchanged.
+func strftime(format string, t time.Time) string {
+	var builder strings.Builder
+
+	for index := 0; index < len(format); index++ {
+		if format[index] != '%' || index+1 == len(format) {
+			builder.WriteByte(format[index])
+			continue
+		}
+
+		index++
+		switch format[index] {
+		case 'a':
+			builder.WriteString(t.Format("Mon"))
+		case 'A':
+			builder.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			builder.WriteString(t.Format("Jan"))
+		case 'B':
+			builder.WriteString(t.Format("January"))
+		case 'c':
+			builder.WriteString(t.Format("Mon Jan _2 15:04:05 2006"))
+		case 'C':
+			builder.WriteString(fmt.Sprintf("%02d", t.Year()/100))
+		case 'd':
+			builder.WriteString(t.Format("02"))
+		case 'D', 'x':
+			builder.WriteString(t.Format("01/02/06"))
+		case 'e':
+			builder.WriteString(t.Format("_2"))
+		case 'F':
+			builder.WriteString(t.Format("2006-01-02"))
+		case 'H':
+			builder.WriteString(t.Format("15"))
+		case 'I':
+			builder.WriteString(t.Format("03"))
+		case 'j':
+			builder.WriteString(fmt.Sprintf("%03d", t.YearDay()))
+		case 'k':
+			builder.WriteString(fmt.Sprintf("%2d", t.Hour()))
+		case 'l':
+			builder.WriteString(fmt.Sprintf("%2d", (t.Hour()+11)%12+1))
+		case 'm':
+			builder.WriteString(t.Format("01"))
+		case 'M':
+			builder.WriteString(t.Format("04"))
+		case 'n':
+			builder.WriteByte('\n')
+		case 'p':
+			builder.WriteString(t.Format("PM"))
+		case 'P':
+			builder.WriteString(t.Format("pm"))
+		case 'r':
+			builder.WriteString(t.Format("03:04:05 PM"))
+		case 'R':
+			builder.WriteString(t.Format("15:04"))
+		case 's':
+			builder.WriteString(fmt.Sprintf("%d", t.Unix()))
+		case 'S':
+			builder.WriteString(t.Format("05"))
+		case 't':
+			builder.WriteByte('\t')
+		case 'T', 'X':
+			builder.WriteString(t.Format("15:04:05"))
+		case 'u':
+			// Monday is 1 and Sunday 7
+			builder.WriteString(fmt.Sprintf("%d", (int(t.Weekday())+6)%7+1))
+		case 'w':
+			builder.WriteString(fmt.Sprintf("%d", int(t.Weekday())))
+		case 'y':
+			builder.WriteString(t.Format("06"))
+		case 'Y':
+			builder.WriteString(t.Format("2006"))
+		case 'z':
+			builder.WriteString(t.Format("-0700"))
+		case 'Z':
+			builder.WriteString(t.Format("MST"))
+		case '%':
+			builder.WriteByte('%')
+		default:
+			builder.WriteByte('%')
+			builder.WriteByte(format[index])
+		}
+	}
+
+	return builder.String()
+}
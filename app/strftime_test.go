@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStrftime(t *testing.T) {
+	// a Tuesday afternoon
+	moment := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.UTC)
+
+	tests := map[string]string{
+		"%F %T":         "2024-03-05 14:07:09",
+		"%d/%m/%y":      "05/03/24",
+		"%a %b %e":      "Tue Mar  5",
+		"%A %B":         "Tuesday March",
+		"%I:%M %p":      "02:07 PM",
+		"%l%P":          " 2pm",
+		"%j %u %w":      "065 2 2",
+		"%s":            "1709647629",
+		"%C%y %Z %z":    "2024 UTC +0000",
+		"100%% %q":      "100% %q",
+		"trailing %":    "trailing %",
+		"%R%t%D%n":      "14:07\t03/05/24\n",
+		"no conversion": "no conversion",
+	}
+
+	for format, want := range tests {
+		if got := strftime(format, moment); got != want {
+			t.Errorf("strftime(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestHistoryTimestamps(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	moment := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.Local)
+	shell.history = &historyCache{memory: []historyEntry{
+		{line: "make", time: moment},
+		{line: "from an old file"},
+	}}
+
+	t.Setenv("HISTTIMEFORMAT", "%F %T ")
+	listing := runCaptured("history")
+	want := "    0  2024-03-05 14:07:09 make\n    1  ??from an old file\n"
+	if listing != want {
+		t.Errorf("history with HISTTIMEFORMAT printed %q, want %q", listing, want)
+	}
+
+	// the file keeps the time as a #epoch comment, which reads back
+	file := formatHistory(shell.history.memory)
+	if want := fmt.Sprintf("#%d\nmake\nfrom an old file\n", moment.Unix()); file != want {
+		t.Errorf("formatHistory wrote %q, want %q", file, want)
+	}
+	entries, err := parseHistory(strings.NewReader(file))
+	if err != nil || len(entries) != 2 || !entries[0].time.Equal(moment) || !entries[1].time.IsZero() {
+		t.Errorf("the history file %q read back as %+v, %v", file, entries, err)
+	}
+
+	// without HISTTIMEFORMAT neither the listing nor the file has times
+	unsetenv(t, "HISTTIMEFORMAT")
+	if listing := runCaptured("history 1"); listing != "    1  from an old file\n" {
+		t.Errorf("history 1 printed %q", listing)
+	}
+	if file := formatHistory(shell.history.memory); file != "make\nfrom an old file\n" {
+		t.Errorf("formatHistory wrote %q", file)
+	}
+}
+
+// unsetenv removes a variable for the rest of the test. t.Setenv first
+// records the value to put back.
+func unsetenv(t *testing.T, name string) {
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
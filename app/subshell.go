@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// runCommandLine runs a list of pipelines joined by ;, && and ||. Each
+// pipeline stage is a simple command, a ( subshell ) or a { brace group; }.
+func runCommandLine(line string) {
+	line, cleanup, err := startHereDocuments(line)
+	defer cleanup()
+	if err != nil {
+		printErr(fmt.Sprintf("%v\n", err))
+		shell.exitStatus = 1
+		return
+	}
+
+	items, err := splitCommandList(line)
+	if err != nil {
+		printErr(fmt.Sprintf("%v\n", err))
+		shell.exitStatus = 2
+		return
+	}
+
+	for _, item := range items {
+		if item.operator == "&&" && shell.exitStatus != 0 {
+			continue
+		}
+		if item.operator == "||" && shell.exitStatus == 0 {
+			continue
+		}
+
+		runPipeline(item.text)
+	}
+}
+
+func runPipeline(text string) {
+	stages := splitPipeline(text)
+	if len(stages) > 1 {
+		handlePipe(stages)
+		return
+	}
+
+	if kind, inner, rest, ok := parseGroup(text); ok {
+		runGroup(kind, inner, rest)
+		return
+	}
+
+	text, substitutions := startProcessSubstitutions(text)
+	previousSubstitutions := activeSubstitutions
+	activeSubstitutions = append(activeSubstitutions, substitutions...)
+	defer func() {
+		finishProcessSubstitutions(substitutions)
+		activeSubstitutions = previousSubstitutions
+	}()
+
+	executeCommand(SplitArgs(text))
+}
+
+// runGroup runs a subshell or a brace group with the redirections written
+// after it applied to every command inside.
+func runGroup(kind byte, inner string, rest string) {
+	restArgs := SplitArgs(rest)
+
+	if words := filterAndJoinArgs(restArgs); len(words) > 0 {
+		printErr(fmt.Sprintf("syntax error near unexpected token `%s'\n", words[0]))
+		shell.exitStatus = 2
+		return
+	}
+
+	redirectionTargets := findRedirectionTargets(filterEmptyArgs(restArgs))
+
+	withRedirectedStreams(redirectionTargets, func() {
+		if kind == '(' {
+			runSubshell(inner)
+		} else {
+			runCommandLine(inner)
+		}
+	})
+}
+
+// subshellStateVariable passes a subshell the state of the shell that is
+// not in its environment. The subshell removes it before running anything.
+const subshellStateVariable = "SHELL_SUBSHELL_STATE"
+
+// subshellState is what a subshell inherits on top of the environment and
+// the working directory.
+type subshellState struct {
+	ExitStatus     int                 `json:"status"`
+	DirectoryStack []string            `json:"dirs"`
+	SetOptions     map[string]bool     `json:"set"`
+	ShellOptions   map[string]bool     `json:"shopt"`
+	Arrays         map[string][]string `json:"arrays"`
+	// Completions holds complete -p lines, which the subshell runs again
+	Completions []string              `json:"complete"`
+	Hashed      map[string]hashedPath `json:"hash"`
+}
+
+type hashedPath struct {
+	Path   string `json:"path"`
+	Hits   int    `json:"hits"`
+	Pinned bool   `json:"pinned"`
+}
+
+// subshellCommand starts a copy of this shell running commands, so the
+// subshell gets its own working directory, variables and directory stack.
+func subshellCommand(commands string) *exec.Cmd {
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+
+	cmd := exec.Command(self, "-c", commands)
+	cmd.Dir = currentDirectory()
+	cmd.Env = os.Environ()
+	if state, err := encodeSubshellState(); err == nil {
+		cmd.Env = append(cmd.Env, subshellStateVariable+"="+state)
+	}
+	return cmd
+}
+
+func encodeSubshellState() (string, error) {
+	state := subshellState{
+		ExitStatus:     shell.exitStatus,
+		DirectoryStack: shell.directoryStack,
+		SetOptions:     setOptions,
+		ShellOptions:   shellOptions,
+		Arrays:         shellArrays,
+		Hashed:         map[string]hashedPath{},
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(completionSpecs)) {
+		state.Completions = append(state.Completions, formatSpec(name, completionSpecs[name]))
+	}
+	if commandTable.path == os.Getenv("PATH") {
+		for name, hashed := range commandTable.hashed {
+			state.Hashed[name] = hashedPath{Path: hashed.path, Hits: hashed.hits, Pinned: hashed.pinned}
+		}
+	}
+
+	encoded, err := json.Marshal(state)
+	return string(encoded), err
+}
+
+// inheritSubshellState takes over the state the parent shell passed, if
+// this shell was started by subshellCommand.
+func inheritSubshellState() {
+	encoded, ok := os.LookupEnv(subshellStateVariable)
+	if !ok {
+		return
+	}
+	os.Unsetenv(subshellStateVariable)
+
+	var state subshellState
+	if err := json.Unmarshal([]byte(encoded), &state); err != nil {
+		printErr(fmt.Sprintf("subshell: %v\n", err))
+		return
+	}
+
+	shell.exitStatus = state.ExitStatus
+	shell.directoryStack = state.DirectoryStack
+	maps.Copy(setOptions, state.SetOptions)
+	maps.Copy(shellOptions, state.ShellOptions)
+	maps.Copy(shellArrays, state.Arrays)
+
+	for _, line := range state.Completions {
+		handleComplete(SplitArgs(strings.TrimSuffix(line, "\n")))
+	}
+
+	commandTable.path = os.Getenv("PATH")
+	for name, hashed := range state.Hashed {
+		commandTable.hashed[name] = &hashedCommand{path: hashed.Path, hits: hashed.Hits, pinned: hashed.Pinned}
+	}
+}
+
+func runSubshell(commands string) {
+	cmd := subshellCommand(commands)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	shell.exitStatus = exitStatusOf(cmd.Run())
+}
+
+// shellSnapshot is the state the builtins can change. A builtin in a
+// pipeline runs like in a subshell: the snapshot taken before it is put
+// back once it returns, so `echo x | cd /tmp` leaves the shell where it is.
+type shellSnapshot struct {
+	state       shellState
+	environment []string
+	history     historyCache
+	options     map[string]bool
+	setOptions  map[string]bool
+	arrays      map[string][]string
+	completions map[string]completionSpec
+	commands    commandIndex
+	keymaps     map[string]map[rune]keyBinding
+	variables   map[string]string
+}
+
+func takeSnapshot() shellSnapshot {
+	snapshot := shellSnapshot{
+		state:       *shell,
+		environment: os.Environ(),
+		options:     maps.Clone(shellOptions),
+		setOptions:  maps.Clone(setOptions),
+		arrays:      maps.Clone(shellArrays),
+		completions: maps.Clone(completionSpecs),
+		commands: commandIndex{
+			path:        commandTable.path,
+			directories: maps.Clone(commandTable.directories),
+			hashed:      map[string]*hashedCommand{},
+		},
+		keymaps:   map[string]map[rune]keyBinding{},
+		variables: maps.Clone(readlineVariables),
+	}
+
+	if shell.history != nil {
+		snapshot.history = *shell.history
+		snapshot.history.memory = slices.Clone(shell.history.memory)
+		snapshot.history.pending = slices.Clone(shell.history.pending)
+	}
+	for name, hashed := range commandTable.hashed {
+		copied := *hashed
+		snapshot.commands.hashed[name] = &copied
+	}
+	for name, keymap := range keymaps {
+		snapshot.keymaps[name] = maps.Clone(keymap)
+	}
+
+	return snapshot
+}
+
+// restore puts the shell back the way it was when the snapshot was taken,
+// except for the exit status.
+func (snapshot shellSnapshot) restore() {
+	status := shell.exitStatus
+	*shell = snapshot.state
+	shell.exitStatus = status
+
+	os.Clearenv()
+	for _, variable := range snapshot.environment {
+		name, value, _ := strings.Cut(variable, "=")
+		os.Setenv(name, value)
+	}
+
+	if shell.history != nil {
+		*shell.history = snapshot.history
+		shell.history.syncReadline()
+	}
+	shellOptions, setOptions, shellArrays = snapshot.options, snapshot.setOptions, snapshot.arrays
+	completionSpecs, keymaps, readlineVariables = snapshot.completions, snapshot.keymaps, snapshot.variables
+	*commandTable = snapshot.commands
+}
+
+// runPipelineBuiltin runs a builtin stage of a pipeline on a copy of the
+// shell state. exit only ends the stage.
+func runPipelineBuiltin(segment []string) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	shell.pipelineStage = true
+	executeCommand(segment)
+}
+
+// withRedirectedStreams points os.Stdout, os.Stderr and the shared stdin at
+// the redirection targets while run executes, so builtins and programs
+// inside a group all write to the same files.
+func withRedirectedStreams(targets redirectionTargets, run func()) {
+	initializeRedirections(targets)
+
+	stdout, stderr, stdin, reader := os.Stdout, os.Stderr, os.Stdin, stdinReader
+	var opened []*os.File
+
+	defer func() {
+		os.Stdout, os.Stderr, os.Stdin, stdinReader = stdout, stderr, stdin, reader
+		for _, file := range opened {
+			file.Close()
+		}
+	}()
+
+	// the files were already created or truncated by initializeRedirections,
+	// so every command of the group appends to them
+	open := func(path string, flags int) *os.File {
+		absPath, _ := absolutePath(path)
+		file, err := os.OpenFile(absPath, flags, 0644)
+		if err != nil {
+			printErr(fmt.Sprintf("Output error: %v\n", err))
+			return nil
+		}
+		opened = append(opened, file)
+		return file
+	}
+
+	for _, path := range []string{targets.outputRedirect, targets.outputAppend} {
+		if path != "" {
+			if file := open(path, os.O_WRONLY|os.O_APPEND); file != nil {
+				os.Stdout = file
+			}
+		}
+	}
+
+	for _, path := range []string{targets.errRedirect, targets.errAppend} {
+		if path != "" {
+			if file := open(path, os.O_WRONLY|os.O_APPEND); file != nil {
+				os.Stderr = file
+			}
+		}
+	}
+
+	if targets.errToOut {
+		os.Stderr = os.Stdout
+	}
+	if targets.outToErr {
+		os.Stdout = os.Stderr
+	}
+
+	if targets.inputRedirect != "" {
+		if file := open(targets.inputRedirect, os.O_RDONLY); file != nil {
+			os.Stdin = file
+			stdinReader = bufio.NewReader(file)
+		}
+	}
+
+	run()
+}
+
+// captureOutput runs a builtin with its standard output collected in memory
+// so that it can feed the next stage of a pipeline.
+func captureOutput(run func()) io.Reader {
+	var buffer bytes.Buffer
+
+	readSide, writeSide, err := os.Pipe()
+	if err != nil {
+		printErr(fmt.Sprintf("Pipe error: %v\n", err))
+		return &buffer
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buffer, readSide)
+		readSide.Close()
+		close(done)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = writeSide
+	run()
+	os.Stdout = stdout
+
+	writeSide.Close()
+	<-done
+
+	return &buffer
+}
+
+// outputPipes returns readers for the command's stdout and stderr. When one
+// stream is duplicated onto the other (2>&1 or >&2) both share a single pipe
+// and the other reader is empty.
+func outputPipes(cmd *exec.Cmd, targets redirectionTargets) (outPipe io.ReadCloser, errPipe io.ReadCloser) {
+	empty := io.NopCloser(strings.NewReader(""))
+
+	switch {
+	case targets.errToOut:
+		outPipe, _ = cmd.StdoutPipe()
+		cmd.Stderr = cmd.Stdout
+		return outPipe, empty
+	case targets.outToErr:
+		errPipe, _ = cmd.StderrPipe()
+		cmd.Stdout = cmd.Stderr
+		return empty, errPipe
+	}
+
+	outPipe, _ = cmd.StdoutPipe()
+	errPipe, _ = cmd.StderrPipe()
+	return outPipe, errPipe
+}
+
+// stageOutput connects the output of a pipeline stage that is not the
+// last one. Its stdout goes into pipe and its stderr to the shell's stderr,
+// unless the stage redirects them. It returns the files it opened, which
+// the caller closes once the stage has started.
+func stageOutput(cmd *exec.Cmd, targets redirectionTargets, pipe *os.File) ([]*os.File, error) {
+	initializeRedirections(targets)
+
+	stdout, stderr := pipe, os.Stderr
+	var opened []*os.File
+
+	// the files were already created or truncated by initializeRedirections
+	open := func(paths []string, file **os.File) error {
+		for _, path := range paths {
+			if path == "" {
+				continue
+			}
+			absPath, _ := absolutePath(path)
+			opening, err := os.OpenFile(absPath, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return err
+			}
+			opened = append(opened, opening)
+			*file = opening
+		}
+		return nil
+	}
+
+	err := open([]string{targets.outputRedirect, targets.outputAppend}, &stdout)
+	if err == nil {
+		err = open([]string{targets.errRedirect, targets.errAppend}, &stderr)
+	}
+	if err != nil {
+		for _, file := range opened {
+			file.Close()
+		}
+		return nil, err
+	}
+
+	switch {
+	case targets.errToOut:
+		stderr = stdout
+	case targets.outToErr:
+		stdout = stderr
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	return opened, nil
+}
+
+func exitStatusOf(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+
+	return 127
+}
+
+func closePipe(pipe io.Reader) {
+	if closer, ok := pipe.(io.Closer); ok {
+		closer.Close()
+	}
+}
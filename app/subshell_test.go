@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPipelineStageRedirections(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		files map[string]string
+	}{
+		{
+			name:  "stderr to a file",
+			line:  "sh -c 'echo out; echo err >&2' 2> err.log | cat > out.log",
+			files: map[string]string{"err.log": "err\n", "out.log": "out\n"},
+		},
+		{
+			name:  "stderr into the pipe",
+			line:  "sh -c 'echo err >&2' 2>&1 | cat > out.log",
+			files: map[string]string{"out.log": "err\n"},
+		},
+		{
+			name:  "stdout to a file",
+			line:  "echo kept > first.log | cat > out.log",
+			files: map[string]string{"first.log": "kept\n", "out.log": ""},
+		},
+		{
+			name:  "stdout appended",
+			line:  "sh -c 'echo more' >> first.log | cat > out.log",
+			files: map[string]string{"first.log": "before\nmore\n", "out.log": ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			previous := shell.workingDirectory
+			shell.workingDirectory = directory
+			defer func() { shell.workingDirectory = previous }()
+
+			os.WriteFile(filepath.Join(directory, "first.log"), []byte("before\n"), 0644)
+
+			runCommandLine(test.line)
+
+			for name, want := range test.files {
+				got, err := os.ReadFile(filepath.Join(directory, name))
+				if err != nil {
+					t.Errorf("%q did not write %s: %v", test.line, name, err)
+					continue
+				}
+				if string(got) != want {
+					t.Errorf("%q wrote %q to %s, want %q", test.line, got, name, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSubshellStateRoundTrip(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	shell.exitStatus = 3
+	shell.directoryStack = []string{"/usr", "/tmp"}
+	setOptions["xtrace"] = true
+	shellOptions["histappend"] = true
+	shellArrays["list"] = []string{"a", "b c"}
+	completionSpecs["tool"] = completionSpec{wordList: "build test", options: []string{"nospace"}}
+	commandTable.path = os.Getenv("PATH")
+	commandTable.hashed["tool"] = &hashedCommand{path: "/opt/tool", hits: 2, pinned: true}
+
+	encoded, err := encodeSubshellState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// what a freshly started shell has
+	shell.exitStatus = 0
+	shell.directoryStack = nil
+	setOptions["xtrace"] = false
+	shellOptions["histappend"] = false
+	clear(shellArrays)
+	clear(completionSpecs)
+	clear(commandTable.hashed)
+
+	t.Setenv(subshellStateVariable, encoded)
+	inheritSubshellState()
+
+	if _, ok := os.LookupEnv(subshellStateVariable); ok {
+		t.Errorf("%s is still in the environment", subshellStateVariable)
+	}
+	if shell.exitStatus != 3 || !slices.Equal(shell.directoryStack, []string{"/usr", "/tmp"}) {
+		t.Errorf("inherited status %d and stack %q", shell.exitStatus, shell.directoryStack)
+	}
+	if !setOptions["xtrace"] || !shellOptions["histappend"] {
+		t.Errorf("inherited xtrace %v and histappend %v", setOptions["xtrace"], shellOptions["histappend"])
+	}
+	if got := shellArrays["list"]; !slices.Equal(got, []string{"a", "b c"}) {
+		t.Errorf("inherited list %q", got)
+	}
+	if got := formatSpec("tool", completionSpecs["tool"]); got != "complete -o nospace -W 'build test' tool\n" {
+		t.Errorf("inherited the completion %q", got)
+	}
+	if hashed := commandTable.hashed["tool"]; hashed == nil || *hashed != (hashedCommand{path: "/opt/tool", hits: 2, pinned: true}) {
+		t.Errorf("inherited the hashed location %+v", hashed)
+	}
+}
+
+func TestSubshellSeesParentState(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.workingDirectory = t.TempDir()
+
+	runCommandLine("compgen -V list -W 'first second'")
+	runCommandLine("pushd / > /dev/null")
+	runCommandLine("complete -W 'x y' tool")
+
+	tests := []struct{ line, want string }{
+		{line: "(echo ${list[1]})", want: "second\n"},
+		{line: "(echo ${list[0]}) | cat", want: "first\n"},
+		{line: "(dirs -p) | wc -l", want: "2\n"},
+		{line: "(complete -p tool)", want: "complete -W 'x y' tool\n"},
+		{line: "false; (echo $?)", want: "1\n"},
+		{line: "cat <(echo ${list[1]})", want: "second\n"},
+	}
+
+	for _, test := range tests {
+		if got := runCaptured(test.line); strings.TrimLeft(got, " ") != test.want {
+			t.Errorf("%q printed %q, want %q", test.line, got, test.want)
+		}
+	}
+
+	// what the subshell changes stays in it
+	runCommandLine("(popd > /dev/null; compgen -V list -W other)")
+	if len(shell.directoryStack) != 1 || shell.workingDirectory != "/" || shellArrays["list"][0] != "first" {
+		t.Errorf("the subshell changed the stack to %q and the array to %q", shell.directoryStack, shellArrays["list"])
+	}
+}
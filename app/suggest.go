@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/chzyer/readline"
+)
+
+const colorSuggestion = "\x1b[90m"
+
+// autosuggestion shows the rest of a history entry in grey after the
+// cursor, like fish does. Right or End takes all of it, Alt-F its next
+// word. Tab still completes what was typed.
+type autosuggestion struct {
+	history *historyCache
+	// shown is what the last drawn line showed after the cursor
+	shown string
+	// accepted is the text the listener adds to the line
+	accepted string
+}
+
+// suggest returns the text to show after a command line, which is only
+// offered while the cursor is at its end.
+// It is cut short where the terminal row ends.
+func (s *autosuggestion) suggest(line []rune, pos int) string {
+	s.shown = ""
+	if !shell.editingCommand || !shellOptions["autosuggest"] || len(line) == 0 || pos != len(line) {
+		return ""
+	}
+
+	entry := s.history.suggestion(string(line))
+	if entry == "" {
+		return ""
+	}
+	suggestion := entry[len(string(line)):]
+
+	width, _ := terminalSize()
+	column := (textWidth(shell.prompt) + readline.Runes{}.WidthAll(line)) % width
+	room := width - column - 1
+	if room <= 0 {
+		return ""
+	}
+	suggestion = truncateToWidth(suggestion, room)
+
+	s.shown = suggestion
+	return suggestion
+}
+
+// paint is what follows the line when it has a suggestion: the suggestion
+// in grey and the move back to the cursor.
+func (s *autosuggestion) paint(line []rune, pos int) string {
+	suggestion := s.suggest(line, pos)
+	if suggestion == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s%s%s\x1b[%dD", colorSuggestion, suggestion, colorReset, textWidth(suggestion))
+}
+
+// filter turns the keys accepting the suggestion into CharBell, which
+// readline ignores, and leaves the text to add for the listener.
+func (s *autosuggestion) filter(r rune) (rune, bool) {
+	if s.shown == "" {
+		return r, true
+	}
+
+	switch r {
+	case readline.CharForward, readline.CharLineEnd:
+		s.accepted = s.shown
+	case readline.MetaForward:
+		s.accepted = nextSuggestedWord(s.shown)
+	default:
+		return r, true
+	}
+	return readline.CharBell, true
+}
+
+func (s *autosuggestion) OnChange(line []rune, pos int, key rune) (newLine []rune, newPos int, ok bool) {
+	if s.accepted == "" {
+		return nil, 0, false
+	}
+
+	newLine = append(line[:len(line):len(line)], []rune(s.accepted)...)
+	s.accepted = ""
+	return newLine, len(newLine), true
+}
+
+// suggestion finds the entry to suggest for what was typed: the most
+// recent entry starting with it that was run in the current directory, or
+// else the most recent one starting with it anywhere.
+func (history *historyCache) suggestion(typed string) string {
+	directory := currentDirectory()
+	fallback := ""
+
+	for index := len(history.memory) - 1; index >= 0; index-- {
+		entry := history.memory[index]
+		// a multi-line entry cannot be shown after the cursor
+		if len(entry.line) <= len(typed) || !strings.HasPrefix(entry.line, typed) || strings.Contains(entry.line, "\n") {
+			continue
+		}
+		if entry.directory == directory {
+			return entry.line
+		}
+		if fallback == "" {
+			fallback = entry.line
+		}
+	}
+
+	return fallback
+}
+
+// nextSuggestedWord is the start of suggestion up to the end of its next
+// word, a slash ending a word like a space does.
+func nextSuggestedWord(suggestion string) string {
+	runes := []rune(suggestion)
+	end := 0
+	for end < len(runes) && unicode.IsSpace(runes[end]) {
+		end++
+	}
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+		if runes[end-1] == '/' {
+			break
+		}
+	}
+	return string(runes[:end])
+}
+
+// truncateToWidth cuts text to at most width columns.
+func truncateToWidth(text string, width int) string {
+	for text != "" && textWidth(text) > width {
+		runes := []rune(text)
+		text = string(runes[:len(runes)-1])
+	}
+	return text
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chzyer/readline"
+)
+
+func TestHistorySuggestion(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.workingDirectory = "/project"
+
+	history := &historyCache{memory: []historyEntry{
+		{line: "make test", directory: "/project"},
+		{line: "make deploy", directory: "/elsewhere"},
+		{line: "cat <<EOF\nbody\nEOF", directory: "/project"},
+		{line: "git push", directory: "/elsewhere"},
+	}}
+
+	tests := map[string]string{
+		// an entry of this directory wins over a more recent one elsewhere
+		"make": "make test",
+		"ma":   "make test",
+		// then the most recent one anywhere
+		"git":    "git push",
+		"make d": "make deploy",
+		// the typed line must be shorter than the entry
+		"make test": "",
+		// a multi-line entry cannot be shown
+		"cat": "",
+		"zz":  "",
+	}
+	for typed, want := range tests {
+		if got := history.suggestion(typed); got != want {
+			t.Errorf("suggestion(%q) = %q, want %q", typed, got, want)
+		}
+	}
+}
+
+func TestAutosuggestionKeys(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+	shell.editingCommand = true
+	shell.prompt = "$ "
+
+	history := &historyCache{memory: []historyEntry{{line: "git commit --amend"}}}
+	suggester := &autosuggestion{history: history}
+	line := []rune("git c")
+
+	// shopt autosuggest is off unless it is turned on
+	if shown := suggester.paint(line, len(line)); shown != "" {
+		t.Fatalf("with autosuggest off %q was shown", shown)
+	}
+	shellOptions["autosuggest"] = true
+
+	if shown := suggester.paint(line, len(line)); !strings.Contains(shown, "ommit --amend") {
+		t.Fatalf("the line %q was followed by %q", string(line), shown)
+	}
+	if shown := suggester.paint(line, 2); shown != "" {
+		t.Errorf("with the cursor inside the line %q was shown", shown)
+	}
+
+	// press runs a key through the filter and the listener like readline
+	press := func(key rune) {
+		suggester.paint(line, len(line))
+		filtered, _ := suggester.filter(key)
+		if filtered == key {
+			t.Fatalf("key %d was not taken by the suggestion", key)
+		}
+		if newLine, _, ok := suggester.OnChange(line, len(line), filtered); ok {
+			line = newLine
+		}
+	}
+
+	press(readline.MetaForward)
+	if string(line) != "git commit" {
+		t.Errorf("Meta-f took the line to %q, want the next word", string(line))
+	}
+	press(readline.CharLineEnd)
+	if string(line) != "git commit --amend" {
+		t.Errorf("End took the line to %q, want all of the suggestion", string(line))
+	}
+
+	// nothing is left to suggest, so the keys move the cursor as usual
+	suggester.paint(line, len(line))
+	if filtered, _ := suggester.filter(readline.CharForward); filtered != readline.CharForward {
+		t.Errorf("without a suggestion Right became %d", filtered)
+	}
+}
+
+func TestNextSuggestedWord(t *testing.T) {
+	tests := map[string]string{
+		"mit --amend":  "mit",
+		" --amend":     " --amend",
+		"src/app/main": "src/",
+		"":             "",
+		"  ":           "  ",
+	}
+	for suggestion, want := range tests {
+		if got := nextSuggestedWord(suggestion); got != want {
+			t.Errorf("nextSuggestedWord(%q) = %q, want %q", suggestion, got, want)
+		}
+	}
+
+	if got := truncateToWidth("日本語テキスト", 6); got != "日本語" {
+		t.Errorf("truncateToWidth cut wide characters to %q", got)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/chzyer/readline"
+	"golang.org/x/sys/unix"
+)
+
+const charCtrlX = 0x18
+
+// terminalInput is the stdin readline reads keys from. Readline keeps a
+// read pending between key presses, so the input is paused while another
+// program such as an editor owns the terminal, otherwise readline would
+// take its keys.
+type terminalInput struct {
+	fd int
+	// wake interrupts a pending poll when the input is paused or closed
+	wake    [2]int
+	mutex   sync.Mutex
+	resumed *sync.Cond
+	paused  bool
+	closed  bool
+}
+
+func newTerminalInput(fd int) (*terminalInput, error) {
+	input := &terminalInput{fd: fd}
+	if err := unix.Pipe2(input.wake[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return nil, err
+	}
+	input.resumed = sync.NewCond(&input.mutex)
+	return input, nil
+}
+
+func (input *terminalInput) Read(p []byte) (int, error) {
+	for {
+		input.mutex.Lock()
+		for input.paused && !input.closed {
+			input.resumed.Wait()
+		}
+		closed := input.closed
+		input.mutex.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+
+		fds := []unix.PollFd{
+			{Fd: int32(input.fd), Events: unix.POLLIN},
+			{Fd: int32(input.wake[0]), Events: unix.POLLIN},
+		}
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			return 0, err
+		}
+
+		if fds[1].Revents != 0 {
+			var drain [16]byte
+			unix.Read(input.wake[0], drain[:])
+			continue
+		}
+
+		// the input may have been paused while poll was returning
+		input.mutex.Lock()
+		if input.paused || input.closed {
+			input.mutex.Unlock()
+			continue
+		}
+		n, err := unix.Read(input.fd, p)
+		input.mutex.Unlock()
+
+		switch {
+		case err == unix.EINTR || err == unix.EAGAIN:
+			continue
+		case err != nil:
+			return 0, err
+		case n == 0:
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+}
+
+// pause stops reading the terminal until resume is called.
+func (input *terminalInput) pause() {
+	if input == nil {
+		return
+	}
+
+	input.mutex.Lock()
+	input.paused = true
+	input.mutex.Unlock()
+	unix.Write(input.wake[1], []byte{0})
+}
+
+// resume lets readline read the terminal again. It is called before every
+// line is read, so a paused input never outlives the command that paused it.
+func (input *terminalInput) resume() {
+	if input == nil {
+		return
+	}
+
+	input.mutex.Lock()
+	input.paused = false
+	input.resumed.Broadcast()
+	input.mutex.Unlock()
+}
+
+func (input *terminalInput) Close() error {
+	input.mutex.Lock()
+	input.closed = true
+	input.resumed.Broadcast()
+	input.mutex.Unlock()
+	unix.Write(input.wake[1], []byte{0})
+	return nil
+}
+
+// editLineBinding implements Ctrl-X Ctrl-E, which opens the line being
+// typed in the editor and runs what the editor leaves behind.
+type editLineBinding struct {
+	afterCtrlX bool
+	// requested is set when the line readline returned is to be edited
+	requested bool
+}
+
+func (binding *editLineBinding) filter(r rune) (rune, bool) {
+	if binding.afterCtrlX {
+		binding.afterCtrlX = false
+		if r == readline.CharLineEnd {
+			// Enter hands the line over to the main loop, which runs the
+			// editor once readline has left raw mode
+			binding.requested = true
+			return readline.CharEnter, true
+		}
+		return r, true
+	}
+
+	if r == charCtrlX {
+		binding.afterCtrlX = true
+		return r, false
+	}
+	return r, true
+}
+
+// inputFilters lets several filters see the keys readline reads, in order.
+// A key one of them swallows is not passed to the next ones.
+type inputFilters []func(rune) (rune, bool)
+
+func (filters inputFilters) filter(r rune) (rune, bool) {
+	for _, filter := range filters {
+		var process bool
+		if r, process = filter(r); !process {
+			return r, false
+		}
+	}
+	return r, true
+}
+
+// linePainter draws the line being edited, colored when it is a command
+// line, together with its autosuggestion and the completion menu below it.
+type linePainter struct {
+	completer *CustomCompleter
+	suggester *autosuggestion
+}
+
+func (painter *linePainter) Paint(line []rune, pos int) []rune {
+	painted := slices.Clone(line)
+	if shell.editingCommand && highlightEnabled() {
+		painted = []rune(highlightLine(line))
+	}
+
+	width, height := terminalSize()
+	if rows := painter.completer.menu.render(width, height); len(rows) > 0 {
+		painter.suggester.shown = ""
+		painted = append(painted, []rune(belowLine(line, rows))...)
+	} else {
+		painted = append(painted, []rune(painter.suggester.paint(line, pos))...)
+	}
+	return painted
+}
+
+// terminalSize returns the width and height of the terminal, or the usual
+// 80 by 24 when stdout is not one.
+func terminalSize() (int, int) {
+	size, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || size.Col == 0 || size.Row == 0 {
+		return 80, 24
+	}
+	return int(size.Col), int(size.Row)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// shellArrays holds indexed arrays such as the ones filled by read -a.
+// Scalar variables live in the process environment so children see them.
+var shellArrays = map[string][]string{}
+
+func setVariable(name, value string) {
+	delete(shellArrays, name)
+	os.Setenv(name, value)
+}
+
+func setArray(name string, values []string) {
+	os.Unsetenv(name)
+	shellArrays[name] = values
+}
+
+// lookupVariable resolves the inside of a ${...} reference: a plain name,
+// name[index], name[@] / name[*] for every element, or #name[@] for the
+// number of elements. A plain array name yields its first element.
+func lookupVariable(expression string) string {
+	if rest, ok := strings.CutPrefix(expression, "#"); ok {
+		name, subscript, isSubscripted := splitSubscript(rest)
+		if isSubscripted && (subscript == "@" || subscript == "*") {
+			return strconv.Itoa(len(shellArrays[name]))
+		}
+		return strconv.Itoa(len([]rune(lookupVariable(rest))))
+	}
+
+	name, subscript, isSubscripted := splitSubscript(expression)
+
+	values, isArray := shellArrays[name]
+	if !isArray {
+		if isSubscripted && subscript != "0" && subscript != "@" && subscript != "*" {
+			return ""
+		}
+		return os.Getenv(name)
+	}
+
+	if !isSubscripted {
+		subscript = "0"
+	}
+
+	if subscript == "@" || subscript == "*" {
+		return strings.Join(values, " ")
+	}
+
+	// like in an arithmetic context, a subscript can name a variable
+	if isValidVariableName(subscript) {
+		subscript = os.Getenv(subscript)
+	}
+	index, err := strconv.Atoi(subscript)
+	if err != nil {
+		return ""
+	}
+	if index < 0 {
+		index += len(values)
+	}
+	if index < 0 || index >= len(values) {
+		return ""
+	}
+
+	return values[index]
+}
+
+func splitSubscript(expression string) (name, subscript string, ok bool) {
+	open := strings.IndexByte(expression, '[')
+	if open == -1 || !strings.HasSuffix(expression, "]") {
+		return expression, "", false
+	}
+
+	return expression[:open], expression[open+1 : len(expression)-1], true
+}
+
+// handleExport sets NAME=value pairs. Every scalar already lives in the
+// environment, so a bare NAME is accepted as it is.
+func handleExport(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+	if len(words) > 0 && words[0] == "-p" {
+		words = words[1:]
+	} else if len(words) > 0 && strings.HasPrefix(words[0], "-") {
+		outputStream(strings.NewReader(fmt.Sprintf("export: %s: invalid option\n", words[0])), redirectionTargets, true)
+		shell.exitStatus = 2
+		return
+	}
+
+	if len(words) == 0 {
+		variables := os.Environ()
+		sort.Strings(variables)
+
+		var result strings.Builder
+		for _, variable := range variables {
+			name, value, _ := strings.Cut(variable, "=")
+			result.WriteString(fmt.Sprintf("declare -x %s=%s\n", name, doubleQuoteWord(value)))
+		}
+		outputStream(strings.NewReader(result.String()), redirectionTargets, false)
+		return
+	}
+
+	for _, word := range words {
+		name, value, hasValue := strings.Cut(word, "=")
+		if !isValidVariableName(name) {
+			outputStream(strings.NewReader(fmt.Sprintf("export: `%s': not a valid identifier\n", word)), redirectionTargets, true)
+			shell.exitStatus = 1
+			continue
+		}
+		if hasValue {
+			setVariable(name, value)
+		}
+	}
+}
+
+// doubleQuoteWord double quotes text the way bash lists variables, so the
+// parser reads it back unchanged.
+func doubleQuoteWord(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(text) + `"`
+}
+
+// handleUnset removes variables and arrays. There are no shell functions,
+// so unset -f has nothing to remove.
+func handleUnset(args []string) {
+	noSpaceArgs := filterEmptyArgs(args)
+	redirectionTargets := findRedirectionTargets(noSpaceArgs)
+	initializeRedirections(redirectionTargets)
+
+	words := filterAndJoinArgs(args[1:])
+	functions := false
+
+	for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+		switch words[0] {
+		case "-v":
+			functions = false
+		case "-f":
+			functions = true
+		default:
+			outputStream(strings.NewReader(fmt.Sprintf("unset: %s: invalid option\n", words[0])), redirectionTargets, true)
+			shell.exitStatus = 2
+			return
+		}
+		words = words[1:]
+	}
+
+	for _, name := range words {
+		if !isValidVariableName(name) {
+			outputStream(strings.NewReader(fmt.Sprintf("unset: `%s': not a valid identifier\n", name)), redirectionTargets, true)
+			shell.exitStatus = 1
+			continue
+		}
+		if !functions {
+			os.Unsetenv(name)
+			delete(shellArrays, name)
+		}
+	}
+}
+
+// expandVariableAt expands the $NAME or ${NAME} reference starting at index
+// and returns its value together with the index right after the reference.
+// A lone $ is kept as a literal dollar sign.
+func expandVariableAt(input string, index int) (value string, end int) {
+	start := index + 1
+
+	if start < len(input) && input[start] == '?' {
+		return strconv.Itoa(shell.exitStatus), start + 1
+	}
+
+	if start < len(input) && input[start] == '{' {
+		closing := strings.IndexByte(input[start:], '}')
+		if closing == -1 {
+			return "$", start
+		}
+		name := input[start+1 : start+closing]
+		return lookupVariable(name), start + closing + 1
+	}
+
+	end = start
+	for end < len(input) && (input[end] == '_' || isDigit(input[end]) ||
+		unicode.IsLetter(rune(input[end]))) {
+		// names cannot start with a digit
+		if end == start && isDigit(input[end]) {
+			break
+		}
+		end++
+	}
+
+	if end == start {
+		return "$", start
+	}
+
+	return lookupVariable(input[start:end]), end
+}
+
+func isValidVariableName(name string) bool {
+	if name == "" || isDigit(name[0]) {
+		return false
+	}
+
+	for _, char := range name {
+		if char != '_' && !unicode.IsLetter(char) && !unicode.IsDigit(char) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogicalWorkingDirectory(t *testing.T) {
+	snapshot := takeSnapshot()
+	defer snapshot.restore()
+
+	root := t.TempDir()
+	real := filepath.Join(root, "real", "inner")
+	os.MkdirAll(real, 0755)
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Skip("symlinks are not available:", err)
+	}
+
+	t.Run("cd keeps the symlink", func(t *testing.T) {
+		shell.workingDirectory = root
+		runCaptured("cd link")
+		if shell.workingDirectory != link || os.Getenv("PWD") != link {
+			t.Fatalf("cd link moved to %s with PWD %s, want %s", shell.workingDirectory, os.Getenv("PWD"), link)
+		}
+		if got := runCaptured("pwd"); got != link+"\n" {
+			t.Errorf("pwd printed %q, want %q", got, link+"\n")
+		}
+		if got := runCaptured("pwd -P"); got != real+"\n" {
+			t.Errorf("pwd -P printed %q, want %q", got, real+"\n")
+		}
+	})
+
+	t.Run("cd .. leaves the way it came", func(t *testing.T) {
+		shell.workingDirectory = link
+		runCaptured("cd ..")
+		if shell.workingDirectory != root {
+			t.Errorf("cd .. from %s moved to %s, want %s", link, shell.workingDirectory, root)
+		}
+	})
+
+	t.Run("cd -P resolves the symlink", func(t *testing.T) {
+		shell.workingDirectory = root
+		runCaptured("cd -P link")
+		if shell.workingDirectory != real {
+			t.Errorf("cd -P link moved to %s, want %s", shell.workingDirectory, real)
+		}
+		runCaptured("cd ..")
+		if want := filepath.Join(root, "real"); shell.workingDirectory != want {
+			t.Errorf("cd .. after cd -P moved to %s, want %s", shell.workingDirectory, want)
+		}
+	})
+
+	t.Run("relative paths use the logical directory", func(t *testing.T) {
+		shell.workingDirectory = link
+		runCommandLine("echo here > note.txt")
+		if _, err := os.Stat(filepath.Join(real, "note.txt")); err != nil {
+			t.Errorf("echo here > note.txt in %s did not write through the symlink: %v", link, err)
+		}
+	})
+
+	t.Run("an inherited PWD is kept", func(t *testing.T) {
+		previous, _ := os.Getwd()
+		if err := os.Chdir(real); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chdir(previous)
+
+		t.Setenv("PWD", link)
+		initWorkingDirectory()
+		if shell.workingDirectory != link {
+			t.Errorf("started in %s with PWD=%s, the shell is in %s", real, link, shell.workingDirectory)
+		}
+
+		// a PWD that points elsewhere is not trusted
+		t.Setenv("PWD", root)
+		initWorkingDirectory()
+		if shell.workingDirectory != real {
+			t.Errorf("started in %s with PWD=%s, the shell is in %s", real, root, shell.workingDirectory)
+		}
+	})
+}
@@ -3,7 +3,8 @@ module github.com/codecrafters-io/shell-starter-go
 go 1.25.0
 
 require (
-	github.com/chzyer/readline v1.5.1 // indirect
-	golang.org/x/sys v0.40.0 // indirect
-	golang.org/x/term v0.39.0 // indirect
+	github.com/chzyer/readline v1.5.1
+	golang.org/x/sys v0.40.0
 )
+
+require golang.org/x/term v0.39.0 // indirect